@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// ede is an Extended DNS Error (RFC 8914): a resolver's note on why it
+// answered the way it did.
+type ede struct {
+	Code uint16
+	Text string
+}
+
+func (e *ede) String() string {
+	s := fmt.Sprintf("EDE %d", e.Code)
+	if name, ok := dns.ExtendedErrorCodeToString[e.Code]; ok {
+		s += " " + name
+	}
+	if e.Text != "" {
+		s += ": " + e.Text
+	}
+	return s
+}
+
+// edeOf returns the first Extended DNS Error in r, if there is one.
+func edeOf(r *dns.Msg) *ede {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_EDE); ok {
+			return &ede{Code: e.InfoCode, Text: e.ExtraText}
+		}
+	}
+	return nil
+}
+
+// bogusError is a SERVFAIL that goes away when the resolver is asked not
+// to validate: the data is there, it just doesn't check out.
+type bogusError struct {
+	Server string
+	Name   string
+	Qtype  uint16
+	EDE    *ede // nil if the resolver didn't say why
+}
+
+func (e *bogusError) Error() string {
+	// not the server: this ends up in dns_checks.error, which should
+	// read the same whichever resolver saw it
+	s := fmt.Sprintf("bogus: %s %s: SERVFAIL, but answers with CD set",
+		e.Name, dns.TypeToString[e.Qtype])
+	if e.EDE != nil {
+		s += " (" + e.EDE.String() + ")"
+	}
+	return s
+}
+
+// edeOfErr digs the resolver's explanation out of a bogus failure.
+func edeOfErr(err error) *ede {
+	var be *bogusError
+	if errors.As(err, &be) {
+		return be.EDE
+	}
+	return nil
+}
+
+// checkBogus follows up on a SERVFAIL from server by asking again with
+// Checking Disabled. If that gets an answer, it asks once more without
+// CD, in case the SERVFAIL was a blip; if it fails again, validation is
+// what's failing, and the error says so. Otherwise it returns whatever
+// the resolver said last.
+func checkBogus(ctx context.Context, server string, m, servfail *dns.Msg) (*dns.Msg, error) {
+	cd := m.Copy()
+	cd.Id = dns.Id()
+	cd.CheckingDisabled = true
+	r, err := exchange(ctx, server, cd)
+	if err != nil || (r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError) {
+		return servfail, nil
+	}
+
+	r, err = exchange(ctx, server, m)
+	if err != nil || r.Rcode != dns.RcodeServerFailure {
+		return r, err
+	}
+	q := m.Question[0]
+	return r, &lookupError{Code: failBogus, Err: &bogusError{
+		Server: server,
+		Name:   q.Name,
+		Qtype:  q.Qtype,
+		EDE:    edeOf(r),
+	}}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// validating stands in for a validating resolver in front of h, where the
+// zone at zone doesn't check out: anything it serves is SERVFAIL unless CD
+// is set, with an EDE if the query could carry one. Its DS comes from the
+// parent, so that validates fine.
+type validating struct {
+	h    dns.Handler
+	zone string
+}
+
+func (v validating) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	child := dns.IsSubDomain(v.zone, q.Name) &&
+		!(q.Qtype == dns.TypeDS && dns.CanonicalName(q.Name) == dns.CanonicalName(v.zone))
+	if !r.CheckingDisabled && child {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		if r.IsEdns0() != nil {
+			m.SetEdns0(4096, true)
+			opt := m.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_EDE{
+				InfoCode:  dns.ExtendedErrorCodeDNSBogus,
+				ExtraText: "signature expired",
+			})
+		}
+		w.WriteMsg(m)
+		return
+	}
+	v.h.ServeDNS(w, r)
+}
+
+// TestCheckDomainBogus checks that a validating resolver's SERVFAIL makes
+// the check bogus, with the resolver's EDE, whether it's the parent's DS
+// or the zone's own keys that don't check out.
+func TestCheckDomainBogus(t *testing.T) {
+	for _, zone := range []string{"test.", "bogus.test."} {
+		t.Run(zone, func(t *testing.T) {
+			z, _ := signedTree(t)
+			addr := serveDNS(t, validating{z, zone})
+			usePool(t, addr, addr)
+
+			db := testDB(t)
+			id := insertDomain(t, db, "bogus.test", 1)
+			if err := checkDomain(context.Background(), db, id, "bogus.test"); err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				status, code, text, errStr string
+				edeCode                    int
+			)
+			if err := db.QueryRow(`
+				SELECT status, error_code, error, ede_code, ede_text
+				FROM dns_checks`,
+			).Scan(&status, &code, &errStr, &edeCode, &text); err != nil {
+				t.Fatal(err)
+			}
+			if status != statusBogus || code != failBogus {
+				t.Errorf("want bogus/bogus, got %s/%s (%s)", status, code, errStr)
+			}
+			if edeCode != int(dns.ExtendedErrorCodeDNSBogus) || text != "signature expired" {
+				t.Errorf("want EDE 6 \"signature expired\", got %d %q", edeCode, text)
+			}
+			if !strings.Contains(errStr, "DNSSEC Bogus") {
+				t.Errorf("error doesn't mention the EDE: %s", errStr)
+			}
+
+			// the resolver did its job
+			if m := pool.lookup(addr); m.Failures != 0 || m.Streak != 0 {
+				t.Errorf("resolver blamed: %d failures, streak %d", m.Failures, m.Streak)
+			}
+		})
+	}
+}
+
+// TestValidateBehindValidatingResolver makes sure the chain walk gets the
+// data a validating resolver would rather SERVFAIL on, and so can say
+// what's wrong with it.
+func TestValidateBehindValidatingResolver(t *testing.T) {
+	z, _ := signedTree(t)
+	addr := serveDNS(t, validating{z, "bogus.test."})
+	usePool(t, addr, addr)
+
+	v := validateDomain(context.Background(), addr, "bogus.test")
+	if v.Verdict != verdictBogus {
+		t.Fatalf("want bogus, got %s: %s", v.Verdict, v.Reason)
+	}
+	if strings.Contains(v.Reason, "SERVFAIL") {
+		t.Errorf("reason is the resolver's, not ours: %s", v.Reason)
+	}
+}
+
+func TestServfailStaysServfail(t *testing.T) {
+	addr := serveDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	}))
+	usePool(t, addr, addr)
+
+	r, err := query(context.Background(), addr, "example.test", dns.TypeDNSKEY)
+	if err != nil {
+		t.Fatalf("a SERVFAIL with CD set too isn't bogus: %v", err)
+	}
+	if r.Rcode != dns.RcodeServerFailure {
+		t.Errorf("want SERVFAIL, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// exit codes for -bulk, so a script can tell "not everything is signed"
+// from "we couldn't find out"
+const (
+	bulkAllSigned = 0
+	bulkUnsigned  = 1 // at least one domain has no DS
+	bulkFailed    = 2 // at least one lookup failed
+	bulkBroken    = 3 // bad flags, unreadable input, and so on
+)
+
+type bulkResult struct {
+	Domain  string   `json:"domain"`
+	Status  string   `json:"status"` // signed, unsigned or error
+	DS      []string `json:"ds,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	ErrCode string   `json:"error_code,omitempty"`
+}
+
+// readDomains reads one domain per line. Blank lines and #-comments are
+// skipped, and for CSV lines (like the Tranco list) the last field wins.
+func readDomains(r io.Reader) ([]string, error) {
+	var ret []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.LastIndexByte(line, ','); i >= 0 {
+			line = strings.TrimSpace(line[i+1:])
+		}
+		line = strings.ToLower(strings.TrimSuffix(line, "."))
+		if line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret, sc.Err()
+}
+
+func bulkLookup(ctx context.Context, domain string) bulkResult {
+	res := bulkResult{Domain: domain, Status: "unsigned"}
+	rrs, _, err := lookupDS(ctx, domain)
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		res.ErrCode = classify(err)
+		return res
+	}
+	for _, r := range dsRecords(rrs) {
+		res.DS = append(res.DS, fmt.Sprintf("%d %d %d %s",
+			r.KeyTag, r.Algorithm, r.DigestType, r.Digest))
+	}
+	if len(res.DS) > 0 {
+		res.Status = "signed"
+	}
+	return res
+}
+
+// bulkMain is -bulk: it returns the process exit code.
+func bulkMain(path, format string) int {
+	in := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			slog.Error("bulk", "err", err)
+			return bulkBroken
+		}
+		defer f.Close()
+		in = f
+	}
+	workers, err := workersFromEnv()
+	if err != nil {
+		slog.Error("bulk", "err", err)
+		return bulkBroken
+	}
+
+	// results go to stdout; keep stderr for things going wrong
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr,
+		&slog.HandlerOptions{Level: slog.LevelWarn})))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	code, err := runBulk(ctx, in, os.Stdout, format, workers)
+	if err != nil {
+		slog.Error("bulk", "err", err)
+		return bulkBroken
+	}
+	return code
+}
+
+// runBulk looks up DS for every domain in in, the same way the checker
+// does, and writes results to out in input order as they come in. It
+// never touches the database.
+func runBulk(ctx context.Context, in io.Reader, out io.Writer, format string, workers int) (int, error) {
+	emit, flush, err := bulkWriter(out, format)
+	if err != nil {
+		return 0, err
+	}
+	domains, err := readDomains(in)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		jobs    = make(chan int)
+		results = make([]*bulkResult, len(domains))
+		mu      sync.Mutex
+		next    int
+		code    = bulkAllSigned
+		werr    error
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				res := bulkLookup(ctx, domains[i])
+
+				mu.Lock()
+				results[i] = &res
+				for ; next < len(results) && results[next] != nil; next++ {
+					r := results[next]
+					switch {
+					case r.Status == "error":
+						code = bulkFailed
+					case r.Status == "unsigned" && code == bulkAllSigned:
+						code = bulkUnsigned
+					}
+					if werr == nil {
+						werr = emit(r)
+					}
+					results[next] = &bulkResult{} // done with it
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for i := range domains {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if werr == nil {
+		werr = flush()
+	}
+	if werr == nil {
+		werr = ctx.Err()
+	}
+	return code, werr
+}
+
+// bulkWriter returns a function that writes one result in format, and
+// one to call at the end.
+func bulkWriter(out io.Writer, format string) (func(*bulkResult) error, func() error, error) {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "DOMAIN\tSTATUS\tDS\tERROR")
+		return func(r *bulkResult) error {
+			ds := "-"
+			if len(r.DS) > 0 {
+				ds = strings.Join(r.DS, "; ")
+			}
+			_, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Domain, r.Status, ds, r.Error)
+			return err
+		}, tw.Flush, nil
+
+	case "csv":
+		cw := csv.NewWriter(out)
+		cw.Write([]string{"domain", "status", "ds_count", "ds", "error_code", "error"})
+		return func(r *bulkResult) error {
+			return cw.Write([]string{
+				r.Domain, r.Status, strconv.Itoa(len(r.DS)),
+				strings.Join(r.DS, ";"), r.ErrCode, r.Error,
+			})
+		}, func() error { cw.Flush(); return cw.Error() }, nil
+
+	case "ndjson":
+		enc := json.NewEncoder(out)
+		return func(r *bulkResult) error { return enc.Encode(r) },
+			func() error { return nil }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown format %q (want table, csv or ndjson)", format)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReadDomains(t *testing.T) {
+	got, err := readDomains(strings.NewReader(
+		"Example.COM.\n\n# audit list\n  1,tranco.test \nplain.test\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"example.com", "tranco.test", "plain.test"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("want %v got %v", want, got)
+	}
+}
+
+func TestRunBulk(t *testing.T) {
+	z, _ := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+	ctx := context.Background()
+	const in = "good.test\nplain.test\nnods.test\nbogus.test\n"
+
+	var out bytes.Buffer
+	code, err := runBulk(ctx, strings.NewReader(in), &out, "ndjson", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != bulkUnsigned {
+		t.Errorf("want exit %d got %d", bulkUnsigned, code)
+	}
+
+	var got []bulkResult
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r bulkResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	want := []struct{ domain, status string }{
+		{"good.test", "signed"},
+		{"plain.test", "unsigned"},
+		{"nods.test", "unsigned"}, // keys, but no DS
+		{"bogus.test", "signed"},  // DS is all we look at here
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d results got %d", len(want), len(got))
+	}
+	for i, w := range want {
+		if got[i].Domain != w.domain || got[i].Status != w.status {
+			t.Errorf("%d: want %s %s got %s %s",
+				i, w.domain, w.status, got[i].Domain, got[i].Status)
+		}
+	}
+	if len(got[0].DS) != 1 {
+		t.Errorf("good.test DS: %v", got[0].DS)
+	}
+
+	for _, format := range []string{"table", "csv"} {
+		out.Reset()
+		code, err := runBulk(ctx, strings.NewReader("good.test\n"), &out, format, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != bulkAllSigned {
+			t.Errorf("%s: want exit %d got %d", format, bulkAllSigned, code)
+		}
+		if lines := strings.Count(out.String(), "\n"); lines != 2 {
+			t.Errorf("%s: want header and one row:\n%s", format, out.String())
+		}
+	}
+
+	if _, err := runBulk(ctx, strings.NewReader(in), &out, "xml", 1); err == nil {
+		t.Error("want an error for an unknown format")
+	}
+}
+
+func TestRunBulkFailure(t *testing.T) {
+	fastRetries(t)
+	z, _ := signedTree(t)
+	addr := serveDNS(t, &flaky{n: 1 << 20, h: z})
+	usePool(t, addr, addr)
+
+	var out bytes.Buffer
+	code, err := runBulk(context.Background(), strings.NewReader("good.test\n"), &out, "csv", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != bulkFailed {
+		t.Errorf("want exit %d got %d", bulkFailed, code)
+	}
+	if !strings.Contains(out.String(), ",error,") ||
+		!strings.Contains(out.String(), failServfail) {
+		t.Errorf("want a servfail error row:\n%s", out.String())
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// CDS/CDNSKEY (RFC 7344, RFC 8078) states, stored in dns_checks.cds_status
+const (
+	cdsNone          = ""
+	cdsInSync        = "in-sync"        // parent DS matches what the child asks for
+	cdsPending       = "pending"        // child asks for DS the parent doesn't have yet
+	cdsDeletePending = "delete-pending" // child asks for DS removal, parent still has it
+)
+
+// cdsState compares what zone asks its parent to publish (via CDS and
+// CDNSKEY) with the DS set the parent actually publishes.
+func cdsState(zone string, ds, cds []dsRecord, cdnskey []dnskeyRecord) string {
+	if len(cds) == 0 && len(cdnskey) == 0 {
+		return cdsNone
+	}
+
+	// RFC 8078 4: algorithm 0 is a request to remove the DS entirely
+	for _, r := range cds {
+		if r.Algorithm == 0 {
+			return deleteState(ds)
+		}
+	}
+	for _, r := range cdnskey {
+		if r.Algorithm == 0 {
+			return deleteState(ds)
+		}
+	}
+
+	// the parent can have more than was asked for, like a standby key's
+	// DS or a second digest type; it just has to have all of it
+	for _, c := range cds {
+		if !slices.ContainsFunc(ds, c.sameAs) {
+			return cdsPending
+		}
+	}
+
+	for _, k := range cdnskey {
+		if !hasDSFor(zone, k, ds) {
+			return cdsPending
+		}
+	}
+	return cdsInSync
+}
+
+func deleteState(ds []dsRecord) string {
+	if len(ds) > 0 {
+		return cdsDeletePending
+	}
+	return cdsInSync
+}
+
+func hasDSFor(zone string, k dnskeyRecord, ds []dsRecord) bool {
+	for _, d := range ds {
+		if d.KeyTag != k.KeyTag || d.Algorithm != k.Algorithm {
+			continue
+		}
+		want := k.toDS(zone, d.DigestType)
+		if want != nil && strings.EqualFold(want.Digest, d.Digest) {
+			return true
+		}
+	}
+	return false
+}
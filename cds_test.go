@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCDSState(t *testing.T) {
+	var (
+		cur  = newTestKey(t, "example.test.", 257)
+		next = newTestKey(t, "example.test.", 257)
+
+		ds      = dsRecords([]dns.RR{cur.ds()})
+		nextDS  = dsRecords([]dns.RR{next.ds()})
+		curKey  = dnskeyRecords([]dns.RR{cur.key})
+		nextKey = dnskeyRecords([]dns.RR{next.key})
+		deleted = dsRecords([]dns.RR{&dns.CDS{DS: dns.DS{Digest: "00"}}})
+		standby = dsRecords([]dns.RR{cur.ds(), next.ds()})
+	)
+
+	for _, tc := range []struct {
+		name    string
+		ds      []dsRecord
+		cds     []dsRecord
+		cdnskey []dnskeyRecord
+		want    string
+	}{
+		{"nothing published", ds, nil, nil, cdsNone},
+		{"cds matches", ds, ds, nil, cdsInSync},
+		{"cdnskey matches", ds, nil, curKey, cdsInSync},
+		{"parent has a standby too", standby, ds, curKey, cdsInSync},
+		{"cds rolled", ds, nextDS, curKey, cdsPending},
+		{"cdnskey rolled", ds, nil, nextKey, cdsPending},
+		{"initial upload", nil, ds, curKey, cdsPending},
+		{"delete requested", ds, deleted, nil, cdsDeletePending},
+		{"delete done", nil, deleted, nil, cdsInSync},
+	} {
+		got := cdsState("example.test", tc.ds, tc.cds, tc.cdnskey)
+		if got != tc.want {
+			t.Errorf("%s: want %q got %q", tc.name, tc.want, got)
+		}
+	}
+}
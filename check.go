@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// what a check established about a domain, stored as dns_checks.status
+const (
+	statusSecure   = "secure"   // DS at the parent, and the chain validates
+	statusInsecure = "insecure" // no DS at the parent
+	statusBogus    = "bogus"    // the chain is broken, by our walk or a resolver's
+	statusUnknown  = "unknown"  // the check failed, so we can't say
+)
+
+// the steps of a check besides the DS lookup, which can fail without
+// failing the check; see checkResult.ProbeErrs
+const (
+	stepNS     = "ns"
+	stepDNSKEY = "dnskey"
+	stepSOA    = "soa"
+	stepDenial = "denial"
+	stepCDS    = "cds"
+	stepMX     = "mx"
+)
+
+// checkResult is everything one round of probes learns about a domain.
+type checkResult struct {
+	HasDNSSEC bool   // the parent publishes DS
+	HasDNSKEY bool   // the zone publishes keys, whether or not there's DS
+	Err       string // the DS lookup failed, or the zone is bogus
+	ErrCode   string // see failure.go
+	EDE       *ede   // why a validating resolver said SERVFAIL, if it did
+	Val       validation
+	DS        []dsRecord
+	DNSKEY    []dnskeyRecord
+	CDS       []dsRecord
+	CDNSKEY   []dnskeyRecord
+	CDSStatus string
+	Denial    denial
+	NSProblem string // how the zone's own nameservers disagree, if they do
+
+	Nameservers []string // the NS set, from the resolvers
+	Provider    string   // who runs DNS for the domain; see providers.go
+	DANE        string   // see dane.go
+
+	// the other probes that failed, by name, and why. What they'd have
+	// told us is carried over from the last check (see keep), so they're
+	// not part of same() either.
+	ProbeErrs map[string]string
+
+	// what each resolver said about DS, what each of the zone's
+	// nameservers said about its keys, and what we found for each MX
+	// host. Like Sigs, not part of same(): the latest answers replace the
+	// old ones on an unchanged check.
+	Answers []resolverAnswer
+	NS      []nsAnswer
+	MX      []mxAnswer
+
+	// what happened to the DS and DNSKEY sets since the last check that
+	// saw them; only saved along with a new history row, which any such
+	// change makes
+	Events []keyEvent
+
+	// signatures over the apex DNSKEY and SOA sets. These change every
+	// time the zone is re-signed, so they're kept per domain rather than
+	// in the check history.
+	Sigs []rrsigRecord
+}
+
+// same reports whether r says the same thing as prev, in which case we
+// don't need a new history row.
+func (r *checkResult) same(prev *checkResult) bool {
+	return r.HasDNSSEC == prev.HasDNSSEC &&
+		r.HasDNSKEY == prev.HasDNSKEY &&
+		r.Err == prev.Err &&
+		r.ErrCode == prev.ErrCode &&
+		r.Val.Verdict == prev.Val.Verdict &&
+		r.CDSStatus == prev.CDSStatus &&
+		r.Denial == prev.Denial &&
+		r.NSProblem == prev.NSProblem &&
+		r.Provider == prev.Provider &&
+		r.DANE == prev.DANE &&
+		slices.Equal(r.Nameservers, prev.Nameservers) &&
+		sameDS(r.DS, prev.DS) &&
+		sameDNSKEY(r.DNSKEY, prev.DNSKEY) &&
+		sameDS(r.CDS, prev.CDS) &&
+		sameDNSKEY(r.CDNSKEY, prev.CDNSKEY)
+}
+
+// Status sums r up from the DS lookup and validation; the other probes
+// don't come into it. A DS set we couldn't validate either way, or that
+// the chain walk found no use for (say, every DS uses an algorithm we
+// don't support), is unknown, not secure; only checks from before
+// validation existed have no verdict.
+func (r *checkResult) Status() string {
+	switch {
+	case r.ErrCode == failBogus:
+		return statusBogus
+	case r.Err != "":
+		return statusUnknown
+	case r.Val.Verdict == verdictBogus:
+		return statusBogus
+	case !r.HasDNSSEC:
+		return statusInsecure
+	case r.Val.Verdict == verdictIndeterminate,
+		r.Val.Verdict == verdictInsecure:
+		return statusUnknown
+	}
+	return statusSecure
+}
+
+func (r *checkResult) fail(err error) *checkResult {
+	r.Err = err.Error()
+	r.ErrCode = classify(err)
+	if r.ErrCode == failBogus {
+		// a resolver did the validating for us
+		r.Val = validation{Verdict: verdictBogus, Reason: r.Err}
+		r.EDE = edeOfErr(err)
+	}
+	return r
+}
+
+// probeFailed notes that one of the probes besides DS didn't work out.
+func (r *checkResult) probeFailed(probe string, err error) {
+	if r.ProbeErrs == nil {
+		r.ProbeErrs = make(map[string]string)
+	}
+	r.ProbeErrs[probe] = err.Error()
+}
+
+func (r *checkResult) failed(probe string) bool {
+	_, ok := r.ProbeErrs[probe]
+	return ok
+}
+
+// ProbeErrors is ProbeErrs in one line, the way dns_checks stores it.
+func (r *checkResult) ProbeErrors() string {
+	var ret []string
+	for _, p := range slices.Sorted(maps.Keys(r.ProbeErrs)) {
+		ret = append(ret, p+": "+r.ProbeErrs[p])
+	}
+	return strings.Join(ret, "; ")
+}
+
+// keep fills in what the probes that failed this time found last time,
+// so that a timeout doesn't read as a change.
+func (r *checkResult) keep(prev *checkResult) {
+	if r.failed(stepNS) {
+		r.Nameservers, r.Provider = prev.Nameservers, prev.Provider
+		r.NSProblem = prev.NSProblem
+	}
+	if r.failed(stepDNSKEY) {
+		// nothing past DNSKEY got looked at either
+		r.HasDNSKEY, r.DNSKEY = prev.HasDNSKEY, prev.DNSKEY
+		r.Denial, r.NSProblem = prev.Denial, prev.NSProblem
+	}
+	if r.failed(stepDenial) {
+		r.Denial = prev.Denial
+	}
+	if r.failed(stepCDS) {
+		r.CDS, r.CDNSKEY, r.CDSStatus = prev.CDS, prev.CDNSKEY, prev.CDSStatus
+	}
+	if r.failed(stepMX) {
+		r.DANE = prev.DANE
+	}
+}
+
+// probeDomain runs every probe against name. Only the DS lookup failing,
+// or the zone's own data turning out bogus, fails the check; the other
+// failures are noted in ProbeErrs and the probes move on.
+func probeDomain(ctx context.Context, name string) *checkResult {
+	var res checkResult
+
+	records, answers, err := lookupDS(ctx, name)
+	res.Answers = answers
+	if err != nil {
+		return res.fail(err)
+	}
+	res.HasDNSSEC = len(records) > 0
+	res.DS = dsRecords(records)
+
+	if ns, _, err := lookupApex(ctx, name, dns.TypeNS); err != nil {
+		res.probeFailed(stepNS, err)
+	} else {
+		res.Nameservers = nsNames(ns)
+		res.Provider = attribute(name, res.Nameservers)
+	}
+
+	// a validating resolver that won't give us the zone's keys, or its
+	// SOA, has found the zone bogus; that's an answer, not a failed probe
+	keys, keySigs, err := lookupApex(ctx, name, dns.TypeDNSKEY)
+	if classify(err) == failBogus {
+		return res.fail(err)
+	} else if err != nil {
+		res.probeFailed(stepDNSKEY, err)
+	}
+	res.HasDNSKEY = len(keys) > 0
+	res.DNSKEY = dnskeyRecords(keys)
+
+	if res.HasDNSKEY {
+		if _, soaSigs, err := lookupApex(ctx, name, dns.TypeSOA); classify(err) == failBogus {
+			return res.fail(err)
+		} else if err != nil {
+			res.probeFailed(stepSOA, err)
+		} else {
+			res.Sigs = rrsigRecords(append(keySigs, soaSigs...))
+		}
+
+		if res.Denial, err = probeDenial(ctx, name); err != nil {
+			res.probeFailed(stepDenial, err)
+		}
+
+		if !res.failed(stepNS) {
+			res.NS = probeNameservers(ctx, name, res.Nameservers)
+			res.NSProblem = nsProblem(res.NS)
+		}
+	}
+
+	cds, _, err := lookupApex(ctx, name, dns.TypeCDS)
+	if err == nil {
+		var cdnskey []dns.RR
+		if cdnskey, _, err = lookupApex(ctx, name, dns.TypeCDNSKEY); err == nil {
+			res.CDS = dsRecords(cds)
+			res.CDNSKEY = dnskeyRecords(cdnskey)
+			res.CDSStatus = cdsState(name, res.DS, res.CDS, res.CDNSKEY)
+		}
+	}
+	if err != nil {
+		res.probeFailed(stepCDS, err)
+	}
+
+	if res.MX, err = probeMail(ctx, name, res.HasDNSSEC); err == nil {
+		err = mxErr(res.MX)
+	}
+	if err != nil {
+		res.probeFailed(stepMX, err)
+	} else {
+		res.DANE = daneStatus(res.MX)
+	}
+
+	for p, e := range res.ProbeErrs {
+		slog.Warn("probe", "domain", name, "probe", p, "err", e)
+	}
+
+	res.Val = validateRetry(ctx, name)
+	if res.Val.Verdict != verdictSecure {
+		slog.Info("validation", "domain", name,
+			"verdict", res.Val.Verdict, "reason", res.Val.Reason)
+	}
+	return &res
+}
+
+// lastCheck loads the most recent check for a domain, or nil if it's
+// never been checked.
+func lastCheck(ctx context.Context, db *sql.DB, domainID int) (int, *checkResult, error) {
+	var (
+		id     int
+		res    checkResult
+		has    sql.NullBool
+		keys   sql.NullBool
+		errStr sql.NullString
+		code   sql.NullString
+		val    sql.NullString
+		cds    sql.NullString
+		den    sql.NullString
+		iters  sql.NullInt64
+		salt   sql.NullInt64
+		optOut sql.NullBool
+		nsProb sql.NullString
+		prov   sql.NullString
+		dane   sql.NullString
+	)
+	err := db.QueryRowContext(ctx, `
+			SELECT id, has_dnssec, has_dnskey, error, error_code,
+				validation, cds_status,
+				denial, nsec3_iterations, nsec3_salt_len, nsec3_opt_out,
+				ns_problem, provider, dane
+        	FROM dns_checks
+            WHERE domain_id = ?
+            ORDER BY checked_at DESC
+            LIMIT 1`,
+		domainID,
+	).Scan(&id, &has, &keys, &errStr, &code, &val, &cds,
+		&den, &iters, &salt, &optOut, &nsProb, &prov, &dane)
+	if err == sql.ErrNoRows {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	res.HasDNSSEC = has.Valid && has.Bool
+	res.HasDNSKEY = keys.Valid && keys.Bool
+	res.Err = errStr.String
+	res.ErrCode = code.String
+	res.Val.Verdict = val.String
+	res.CDSStatus = cds.String
+	res.NSProblem = nsProb.String
+	res.Provider = prov.String
+	res.DANE = dane.String
+	res.Denial = denial{
+		Type:       den.String,
+		Iterations: int(iters.Int64),
+		SaltLen:    int(salt.Int64),
+		OptOut:     optOut.Bool,
+	}
+
+	if res.DS, err = loadDS(ctx, db, "ds_records", id); err != nil {
+		return 0, nil, err
+	}
+	if res.DNSKEY, err = loadDNSKEY(ctx, db, "dnskey_records", id); err != nil {
+		return 0, nil, err
+	}
+	if res.CDS, err = loadDS(ctx, db, "cds_records", id); err != nil {
+		return 0, nil, err
+	}
+	if res.CDNSKEY, err = loadDNSKEY(ctx, db, "cdnskey_records", id); err != nil {
+		return 0, nil, err
+	}
+	if res.Nameservers, err = loadNSNames(ctx, db, id); err != nil {
+		return 0, nil, err
+	}
+	return id, &res, nil
+}
+
+func checkDomain(ctx context.Context, db *sql.DB, id int, name string) error {
+	slog.Info("checking", "domain", name)
+	if err := recordCheck(ctx, db, id, name, probeDomain(ctx, name)); err != nil {
+		return err
+	}
+	return refreshTLD(ctx, db, id)
+}
+
+// recordCheck stores what a probe found, either as a new history row or
+// by confirming the last one.
+func recordCheck(ctx context.Context, db *sql.DB, id int, name string, res *checkResult) error {
+	lastID, last, err := lastCheck(ctx, db, id)
+	if err != nil {
+		return err
+	}
+
+	if last != nil {
+		res.keep(last)
+		if res.failed(stepNS) || res.failed(stepDNSKEY) {
+			if res.NS, err = loadNSAnswers(ctx, db, lastID); err != nil {
+				return err
+			}
+		}
+		// a failed MX lookup leaves nothing to show; failed hosts are
+		// worth seeing
+		if res.failed(stepMX) && res.MX == nil {
+			if res.MX, err = loadMX(ctx, db, lastID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if res.Err == "" && !res.failed(stepDNSKEY) && !res.failed(stepSOA) {
+		if err := saveSignatures(ctx, db, id, res.Sigs); err != nil {
+			return fmt.Errorf("signatures: %w", err)
+		}
+		exp := sigExpiry(res.Sigs)
+		if !exp.IsZero() && time.Until(exp) < expiryWindow {
+			slog.Warn("signatures expiring", "domain", name,
+				"expires", exp, "left", time.Until(exp).Round(time.Minute))
+		}
+	}
+
+	if last != nil && res.same(last) {
+		return touchCheck(ctx, db, lastID, res)
+	}
+
+	if res.Err == "" {
+		ds, keys, ok, err := prevKeys(ctx, db, id)
+		if err != nil {
+			return fmt.Errorf("previous keys: %w", err)
+		}
+		if ok {
+			res.Events = keyEvents(ds, keys, res.DS, res.DNSKEY)
+		}
+		for _, e := range res.Events {
+			slog.Info("key event", "domain", name, "type", e.Type, "detail", e.Detail)
+		}
+	}
+
+	if err := saveCheck(ctx, db, id, res); err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
+
+// saveCheck records res as a new row in the domain's check history.
+func saveCheck(ctx context.Context, db *sql.DB, domainID int, res *checkResult) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		edeCode sql.NullInt64
+		edeText sql.NullString
+	)
+	if res.EDE != nil {
+		edeCode = sql.NullInt64{Int64: int64(res.EDE.Code), Valid: true}
+		edeText = sql.NullString{String: res.EDE.Text, Valid: true}
+	}
+	r, err := tx.ExecContext(ctx, `
+			INSERT INTO dns_checks(domain_id, has_dnssec, has_dnskey,
+				error, error_code, validation, validation_reason, cds_status,
+				denial, nsec3_iterations, nsec3_salt_len, nsec3_opt_out,
+				status, ede_code, ede_text, ns_problem, provider, dane,
+				probe_errors, created_at)
+            VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+				CURRENT_TIMESTAMP)`,
+		domainID, res.HasDNSSEC, res.HasDNSKEY, res.Err, res.ErrCode,
+		res.Val.Verdict, res.Val.Reason, res.CDSStatus,
+		res.Denial.Type, res.Denial.Iterations, res.Denial.SaltLen,
+		res.Denial.OptOut, res.Status(), edeCode, edeText, res.NSProblem,
+		res.Provider, res.DANE, res.ProbeErrors(),
+	)
+	if err != nil {
+		return err
+	}
+	checkID, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := insertDS(ctx, tx, "ds_records", checkID, res.DS); err != nil {
+		return fmt.Errorf("insert ds: %w", err)
+	}
+	if err := insertDNSKEY(ctx, tx, "dnskey_records", checkID, res.DNSKEY); err != nil {
+		return fmt.Errorf("insert dnskey: %w", err)
+	}
+	if err := insertDS(ctx, tx, "cds_records", checkID, res.CDS); err != nil {
+		return fmt.Errorf("insert cds: %w", err)
+	}
+	if err := insertDNSKEY(ctx, tx, "cdnskey_records", checkID, res.CDNSKEY); err != nil {
+		return fmt.Errorf("insert cdnskey: %w", err)
+	}
+	if err := insertAnswers(ctx, tx, checkID, res.Answers); err != nil {
+		return fmt.Errorf("insert answers: %w", err)
+	}
+	if err := insertNSNames(ctx, tx, checkID, res.Nameservers); err != nil {
+		return fmt.Errorf("insert ns: %w", err)
+	}
+	if err := insertNSAnswers(ctx, tx, checkID, res.NS); err != nil {
+		return fmt.Errorf("insert ns answers: %w", err)
+	}
+	if err := insertMX(ctx, tx, checkID, res.MX); err != nil {
+		return fmt.Errorf("insert mx: %w", err)
+	}
+	if err := insertEvents(ctx, tx, domainID, checkID, res.Events); err != nil {
+		return fmt.Errorf("insert events: %w", err)
+	}
+	return tx.Commit()
+}
+
+// touchCheck marks an unchanged check as seen again, with the resolver,
+// nameserver and MX answers and the probe errors from this round.
+func touchCheck(ctx context.Context, db *sql.DB, checkID int, res *checkResult) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE dns_checks
+		SET checked_at = CURRENT_TIMESTAMP, probe_errors = ?
+		WHERE id = ?`,
+		res.ProbeErrors(), checkID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM resolver_answers WHERE check_id = ?`, checkID,
+	); err != nil {
+		return err
+	}
+	if err := insertAnswers(ctx, tx, int64(checkID), res.Answers); err != nil {
+		return fmt.Errorf("insert answers: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM ns_answers WHERE check_id = ?`, checkID,
+	); err != nil {
+		return err
+	}
+	if err := insertNSAnswers(ctx, tx, int64(checkID), res.NS); err != nil {
+		return fmt.Errorf("insert ns answers: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM mx_records WHERE check_id = ?`, checkID,
+	); err != nil {
+		return err
+	}
+	if err := insertMX(ctx, tx, int64(checkID), res.MX); err != nil {
+		return fmt.Errorf("insert mx: %w", err)
+	}
+	return tx.Commit()
+}
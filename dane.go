@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// what a check found about DANE for a domain's mail, stored as
+// dns_checks.dane. "" is a check from before we looked, or one where the
+// MX lookup has failed every time so far.
+const (
+	daneNoMail   = "no-mail"  // no MX, or a null MX (RFC 7505)
+	daneNone     = "none"     // no TLSA for any MX host
+	danePartial  = "partial"  // usable TLSA for some MX hosts, not all
+	daneFull     = "dane"     // usable TLSA for every MX host
+	daneUnsigned = "unsigned" // TLSA only in unsigned zones, where nothing can trust it
+)
+
+// the most MX hosts we'll look at, lowest preference first
+const maxMX = 4
+
+// mxAnswer is what we found about one of a domain's mail exchangers.
+type mxAnswer struct {
+	Host       string
+	Preference uint16
+	Zone       string // the host's registrable domain, which needs a DS
+	ZoneSigned bool
+	TLSA       []string // _25._tcp.<host>, rdata only
+	Err        string
+}
+
+// Usable reports whether a sending server could do DANE with this host:
+// TLSA records, with a chain of trust to them.
+func (a mxAnswer) Usable() bool {
+	return a.ZoneSigned && len(a.TLSA) > 0
+}
+
+// probeMail looks up domain's MX set and, for each host, whether its zone
+// is signed and whether it publishes TLSA for SMTP. hasDS is whether the
+// domain itself has a DS, so MX hosts inside it don't cost a lookup.
+// Failing on one host is noted in its answer; only a failed MX lookup is
+// an error.
+func probeMail(ctx context.Context, domain string, hasDS bool) ([]mxAnswer, error) {
+	set, _, err := lookupApex(ctx, domain, dns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+	var mxs []*dns.MX
+	for _, rr := range set {
+		// a null MX says the domain takes no mail
+		if mx, ok := rr.(*dns.MX); ok && mx.Mx != "." {
+			mxs = append(mxs, mx)
+		}
+	}
+	slices.SortFunc(mxs, func(a, b *dns.MX) int {
+		if a.Preference != b.Preference {
+			return int(a.Preference) - int(b.Preference)
+		}
+		return strings.Compare(dns.CanonicalName(a.Mx), dns.CanonicalName(b.Mx))
+	})
+	if len(mxs) > maxMX {
+		mxs = mxs[:maxMX]
+	}
+
+	signed := map[string]bool{dns.CanonicalName(domain): hasDS}
+	var ret []mxAnswer
+	for _, mx := range mxs {
+		a := mxAnswer{Host: dns.CanonicalName(mx.Mx), Preference: mx.Preference}
+		_, zone := suffixes.split(a.Host)
+		if zone == "" {
+			zone = a.Host
+		}
+		a.Zone = dns.CanonicalName(zone)
+
+		s, ok := signed[a.Zone]
+		if !ok {
+			ds, _, err := lookupDS(ctx, a.Zone)
+			if err != nil {
+				a.Err = fmt.Sprintf("ds %s: %v", a.Zone, err)
+				ret = append(ret, a)
+				continue
+			}
+			s = len(ds) > 0
+			signed[a.Zone] = s
+		}
+		a.ZoneSigned = s
+
+		if a.TLSA, err = lookupTLSA(ctx, a.Host); err != nil {
+			a.Err = err.Error()
+		}
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
+
+// lookupTLSA returns the TLSA records for SMTP on host, following a CNAME
+// if there is one.
+func lookupTLSA(ctx context.Context, host string) ([]string, error) {
+	r, err := query(ctx, pool.one(), "_25._tcp."+host, dns.TypeTLSA)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		return nil, rcodeError("tlsa", r.Rcode)
+	}
+	var ret []string
+	for _, rr := range r.Answer {
+		if t, ok := rr.(*dns.TLSA); ok {
+			ret = append(ret, fmt.Sprintf("%d %d %d %s",
+				t.Usage, t.Selector, t.MatchingType, strings.ToUpper(t.Certificate)))
+		}
+	}
+	slices.Sort(ret)
+	return ret, nil
+}
+
+// mxErr is the first MX host probeMail couldn't look at, if any. Without
+// all of them there's no saying how DANE is doing, and a timeout
+// shouldn't read as a downgrade, so the check keeps its last status.
+func mxErr(answers []mxAnswer) error {
+	for _, a := range answers {
+		if a.Err != "" {
+			return fmt.Errorf("%s: %s", a.Host, a.Err)
+		}
+	}
+	return nil
+}
+
+// daneStatus sums up a domain's MX answers, which mxErr has passed.
+func daneStatus(answers []mxAnswer) string {
+	if len(answers) == 0 {
+		return daneNoMail
+	}
+	var usable, published int
+	for _, a := range answers {
+		if len(a.TLSA) > 0 {
+			published++
+		}
+		if a.Usable() {
+			usable++
+		}
+	}
+	switch {
+	case usable == len(answers):
+		return daneFull
+	case usable > 0:
+		return danePartial
+	case published > 0:
+		return daneUnsigned
+	}
+	return daneNone
+}
+
+func loadMX(ctx context.Context, db *sql.DB, checkID int) ([]mxAnswer, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT host, preference, zone, zone_signed, tlsa, error
+		FROM mx_records
+		WHERE check_id = ?
+		ORDER BY id`,
+		checkID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []mxAnswer
+	for rows.Next() {
+		var (
+			a    mxAnswer
+			tlsa string
+		)
+		if err := rows.Scan(
+			&a.Host, &a.Preference, &a.Zone, &a.ZoneSigned, &tlsa, &a.Err,
+		); err != nil {
+			return nil, err
+		}
+		if tlsa != "" {
+			a.TLSA = strings.Split(tlsa, "\n")
+		}
+		ret = append(ret, a)
+	}
+	return ret, rows.Err()
+}
+
+func insertMX(ctx context.Context, tx *sql.Tx, checkID int64, answers []mxAnswer) error {
+	for _, a := range answers {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO mx_records(check_id, host, preference, zone,
+				zone_signed, tlsa, error)
+			VALUES(?, ?, ?, ?, ?, ?, ?)`,
+			checkID, a.Host, a.Preference, a.Zone, a.ZoneSigned,
+			strings.Join(a.TLSA, "\n"), a.Err,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mailStats struct {
+	Domains  int     // top-N domains that take mail
+	DANE     float64 // usable TLSA on every MX host
+	Partial  float64 // on some of them
+	Unsigned float64 // TLSA that nothing can trust
+	SignedMX float64 // every MX host in a signed zone
+}
+
+// mailRatios is how the top-N domains that take mail are doing with
+// DANE for SMTP.
+func mailRatios(ctx context.Context, db *sql.DB, limit int) (mailStats, error) {
+	var (
+		st                                 mailStats
+		full, partial, unsigned, allSigned int
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+		       COALESCE(SUM(c.dane = ?), 0),
+		       COALESCE(SUM(c.dane = ?), 0),
+		       COALESCE(SUM(c.dane = ?), 0),
+		       COALESCE(SUM(NOT EXISTS (
+		           SELECT 1 FROM mx_records m
+		           WHERE m.check_id = c.id AND NOT m.zone_signed
+		       )), 0)
+		FROM domains d
+		JOIN dns_checks c ON c.id = (
+			SELECT id FROM dns_checks dc
+			WHERE dc.domain_id = d.id
+			ORDER BY dc.checked_at DESC LIMIT 1
+		)
+		WHERE d.rank <= ? AND c.dane IN (?, ?, ?, ?)`,
+		daneFull, danePartial, daneUnsigned, limit,
+		daneNone, danePartial, daneFull, daneUnsigned,
+	).Scan(&st.Domains, &full, &partial, &unsigned, &allSigned)
+	if err != nil || st.Domains == 0 {
+		return st, err
+	}
+	st.DANE = 100 * float64(full) / float64(st.Domains)
+	st.Partial = 100 * float64(partial) / float64(st.Domains)
+	st.Unsigned = 100 * float64(unsigned) / float64(st.Domains)
+	st.SignedMX = 100 * float64(allSigned) / float64(st.Domains)
+	return st, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDANEStatus(t *testing.T) {
+	var (
+		usable   = mxAnswer{ZoneSigned: true, TLSA: []string{"3 1 1 AA"}}
+		unsigned = mxAnswer{TLSA: []string{"3 1 1 AA"}}
+		plain    = mxAnswer{ZoneSigned: true}
+		failed   = mxAnswer{Err: "timeout"}
+	)
+	for _, tc := range []struct {
+		name    string
+		answers []mxAnswer
+		want    string
+	}{
+		{"no mx", nil, daneNoMail},
+		{"all", []mxAnswer{usable, usable}, daneFull},
+		{"some", []mxAnswer{usable, plain}, danePartial},
+		{"unsigned", []mxAnswer{unsigned, plain}, daneUnsigned},
+		{"none", []mxAnswer{plain, plain}, daneNone},
+	} {
+		if got := daneStatus(tc.answers); got != tc.want {
+			t.Errorf("%s: want %s got %s", tc.name, tc.want, got)
+		}
+	}
+
+	// a host we couldn't look at leaves the status as it was
+	if mxErr([]mxAnswer{usable, plain}) != nil {
+		t.Error("mxErr without a failed host")
+	}
+	if mxErr([]mxAnswer{usable, failed}) == nil {
+		t.Error("no mxErr for a failed host")
+	}
+}
+
+func TestProbeMail(t *testing.T) {
+	z, _ := signedTree(t)
+	z.add(
+		mustRR(t, "good.test. 300 IN MX 20 mx.plain.test."),
+		mustRR(t, "good.test. 300 IN MX 10 mx.good.test."),
+		mustRR(t, "_25._tcp.mx.good.test. 300 IN TLSA 3 1 1 0123456789abcdef"),
+		mustRR(t, "_25._tcp.mx.plain.test. 300 IN TLSA 3 1 1 fedcba9876543210"),
+		mustRR(t, "nomail.test. 300 IN MX 0 ."),
+	)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	db := testDB(t)
+	id := insertDomain(t, db, "good.test", 1)
+	ctx := context.Background()
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+	lastID, last, err := lastCheck(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.DANE != danePartial {
+		t.Errorf("want %s, got %s", danePartial, last.DANE)
+	}
+
+	mx, err := loadMX(ctx, db, lastID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mx) != 2 {
+		t.Fatalf("want 2 MX answers, got %+v", mx)
+	}
+	if a := mx[0]; a.Host != "mx.good.test." || a.Zone != "good.test." ||
+		!a.ZoneSigned || !a.Usable() || a.TLSA[0] != "3 1 1 0123456789ABCDEF" {
+		t.Errorf("first MX: %+v", a)
+	}
+	if a := mx[1]; a.Host != "mx.plain.test." || a.ZoneSigned || a.Usable() {
+		t.Errorf("second MX: %+v", a)
+	}
+
+	st, err := mailRatios(ctx, db, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Domains != 1 || st.Partial != 100 || st.DANE != 0 || st.SignedMX != 0 {
+		t.Errorf("mail stats %+v", st)
+	}
+
+	answers, err := probeMail(ctx, "nomail.test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := daneStatus(answers); s != daneNoMail {
+		t.Errorf("null MX: want %s got %s", daneNoMail, s)
+	}
+}
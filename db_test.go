@@ -5,20 +5,28 @@ import (
 	"context"
 	"database/sql"
 	"encoding/csv"
+	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 func testDB(t *testing.T) *sql.DB {
 	t.Helper()
-	db, err := sql.Open("sqlite3", ":memory:")
+	// not :memory:, where a connection the pool throws away (say, one
+	// interrupted by a cancelled context) takes the whole database with it
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
 	if err := applyMigrations(db); err != nil {
 		t.Fatal(err)
 	}
@@ -63,12 +71,17 @@ func insertCheck(
 	t *testing.T, db *sql.DB, name string, ts time.Time, has bool,
 ) {
 	t.Helper()
+	status := statusInsecure
+	if has {
+		status = statusSecure
+	}
 	_, err := db.Exec(
-		`INSERT INTO dns_checks(domain_id, checked_at, has_dnssec, error)
-         VALUES((SELECT id FROM domains WHERE name = ?), ?, ?, '')`,
+		`INSERT INTO dns_checks(domain_id, checked_at, has_dnssec, error, status)
+         VALUES((SELECT id FROM domains WHERE name = ?), ?, ?, '', ?)`,
 		name,
 		ts.UTC().Format(time.RFC3339Nano),
 		has,
+		status,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -94,6 +107,51 @@ func TestDNSSECRatio(t *testing.T) {
 	}
 }
 
+// TestRatiosSkipUnknown makes sure failed checks don't count either way,
+// even when an older check for the same domain had an answer.
+func TestRatiosSkipUnknown(t *testing.T) {
+	db := testDB(t)
+	names := seedDomains(t, db, 4)
+	if _, err := db.Exec("UPDATE domains SET class = 'Tech'"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	insertCheck(t, db, names[0], now, true)
+	insertCheck(t, db, names[1], now, false)
+	insertCheck(t, db, names[2], now.Add(-time.Hour), true)
+	if _, err := db.Exec(
+		`INSERT INTO dns_checks(domain_id, checked_at, has_dnssec, error, status)
+         VALUES((SELECT id FROM domains WHERE name = ?), ?, 0, 'timeout', ?)`,
+		names[2], now.UTC().Format(time.RFC3339Nano), statusUnknown,
+	); err != nil {
+		t.Fatal(err)
+	}
+	// names[3] is never checked
+
+	ctx := context.Background()
+	ratio, err := dnssecRatio(ctx, db, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ratio < 49 || ratio > 51 {
+		t.Errorf("ratio %.1f not 50", ratio)
+	}
+	m, err := classRatios(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := m["Tech"]; v < 49 || v > 51 {
+		t.Errorf("tech pct %.1f not 50", v)
+	}
+	n, err := unknownCount(ctx, db, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d unknown, want 2", n)
+	}
+}
+
 // TestListUnclassed verifies that listUnclassed only returns
 // domains without a class assigned.
 func TestListUnclassed(t *testing.T) {
@@ -136,7 +194,7 @@ func TestNextDomainExcludesRecentlyChecked(t *testing.T) {
 	}
 	recent := names[5]
 	for i := 0; i < 20; i++ {
-		_, name, err := nextDomain(context.Background(), db)
+		_, name, err := nextDomain(context.Background(), db, 1000, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -232,6 +290,7 @@ func TestClassRatios(t *testing.T) {
 	}
 	now := time.Now()
 	insertCheck(t, db, names[0], now, true)
+	insertCheck(t, db, names[1], now, false)
 	insertCheck(t, db, names[2], now, true)
 	m, err := classRatios(context.Background(), db)
 	if err != nil {
@@ -244,3 +303,465 @@ func TestClassRatios(t *testing.T) {
 		t.Fatalf("gov pct %.1f not 100", v)
 	}
 }
+
+func insertDomain(t *testing.T, db *sql.DB, name string, rank int) int {
+	t.Helper()
+	res, err := db.Exec(
+		"INSERT INTO domains(name, rank) VALUES(?, ?)", name, rank,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id)
+}
+
+// TestCheckDomainStoresDS runs a real check against a local resolver and
+// makes sure the DS set lands in ds_records, and that an unchanged set
+// doesn't start a new check row.
+func TestCheckDomainStoresDS(t *testing.T) {
+	z, keys := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	db := testDB(t)
+	id := insertDomain(t, db, "good.test", 1)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		checks int
+		tag    int
+		val    string
+	)
+	if err := db.QueryRow(
+		"SELECT COUNT(*), MAX(validation) FROM dns_checks",
+	).Scan(&checks, &val); err != nil {
+		t.Fatal(err)
+	}
+	if checks != 1 || val != verdictSecure {
+		t.Fatalf("want 1 secure check, got %d %q", checks, val)
+	}
+	if err := db.QueryRow(
+		"SELECT key_tag FROM ds_records",
+	).Scan(&tag); err != nil {
+		t.Fatal(err)
+	}
+	if want := int(keys["good.test."].key.KeyTag()); tag != want {
+		t.Fatalf("want key tag %d got %d", want, tag)
+	}
+}
+
+// TestCheckDomainFailureIsUnknown makes sure a failed check is recorded
+// as unknown instead of inheriting the last check's answer.
+func TestCheckDomainFailureIsUnknown(t *testing.T) {
+	fastRetries(t)
+	z, _ := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	db := testDB(t)
+	id := insertDomain(t, db, "good.test", 1)
+	ctx := context.Background()
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	refused := serveDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+	}))
+	usePool(t, refused, refused)
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query(
+		"SELECT status, has_dnssec FROM dns_checks ORDER BY id",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var (
+			status string
+			has    bool
+		)
+		if err := rows.Scan(&status, &has); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s/%v", status, has))
+	}
+	if want := []string{"secure/true", "unknown/false"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v got %v", want, got)
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	for _, tc := range []struct {
+		res  checkResult
+		want string
+	}{
+		{checkResult{HasDNSSEC: true, Val: validation{Verdict: verdictSecure}}, statusSecure},
+		{checkResult{HasDNSSEC: true}, statusSecure},
+		{checkResult{HasDNSSEC: true, Val: validation{Verdict: verdictInsecure}}, statusUnknown},
+		{checkResult{HasDNSSEC: true, Val: validation{Verdict: verdictIndeterminate}}, statusUnknown},
+		{checkResult{HasDNSSEC: true, Val: validation{Verdict: verdictBogus}}, statusBogus},
+		{checkResult{Val: validation{Verdict: verdictInsecure}}, statusInsecure},
+		{checkResult{Err: "timeout", ErrCode: failTimeout}, statusUnknown},
+		{checkResult{Err: "bogus", ErrCode: failBogus}, statusBogus},
+	} {
+		if got := tc.res.Status(); got != tc.want {
+			t.Errorf("%+v: want %s got %s", tc.res, tc.want, got)
+		}
+	}
+}
+
+// TestCheckDomainProbeFailure makes sure a side lookup failing doesn't
+// fail the check, or start a new history row, when DS came back fine.
+func TestCheckDomainProbeFailure(t *testing.T) {
+	fastRetries(t)
+	z, _ := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	db := testDB(t)
+	id := insertDomain(t, db, "good.test", 1)
+	ctx := context.Background()
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	refused := map[uint16]string{
+		dns.TypeSOA:     stepSOA,
+		dns.TypeCDNSKEY: stepCDS,
+	}
+	flaky := serveDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, ok := refused[r.Question[0].Qtype]; !ok {
+			z.ServeDNS(w, r)
+			return
+		}
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+	}))
+	usePool(t, flaky, flaky)
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		checks    int
+		status    string
+		probeErrs string
+	)
+	if err := db.QueryRow(
+		"SELECT COUNT(*), MAX(status), MAX(probe_errors) FROM dns_checks",
+	).Scan(&checks, &status, &probeErrs); err != nil {
+		t.Fatal(err)
+	}
+	if checks != 1 || status != statusSecure {
+		t.Fatalf("want 1 secure check, got %d %q", checks, status)
+	}
+	for _, step := range refused {
+		if !strings.Contains(probeErrs, step+": ") {
+			t.Fatalf("want a %s probe error, got %q", step, probeErrs)
+		}
+	}
+}
+
+func insertKeys(t *testing.T, db *sql.DB, name string, algs ...uint8) {
+	t.Helper()
+	for i, alg := range algs {
+		_, err := db.Exec(
+			`INSERT INTO dnskey_records(check_id, key_tag, flags,
+                 protocol, algorithm, public_key)
+             VALUES((SELECT c.id FROM dns_checks c
+                     JOIN domains d ON d.id = c.domain_id
+                     WHERE d.name = ?), ?, 257, 3, ?, ?)`,
+			name, i, alg, name+strconv.Itoa(i),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func insertDSRecord(t *testing.T, db *sql.DB, name string, digestType uint8) {
+	t.Helper()
+	_, err := db.Exec(
+		`INSERT INTO ds_records(check_id, key_tag, algorithm,
+             digest_type, digest)
+         VALUES((SELECT c.id FROM dns_checks c
+                 JOIN domains d ON d.id = c.domain_id
+                 WHERE d.name = ?), 1, 8, ?, 'AA')`,
+		name, digestType,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlgorithmStats(t *testing.T) {
+	db := testDB(t)
+	names := seedDomains(t, db, 4)
+	now := time.Now()
+	for i, name := range names {
+		insertCheck(t, db, name, now, i < 3)
+	}
+	insertKeys(t, db, names[0], dns.RSASHA1, dns.RSASHA1NSEC3SHA1)
+	insertKeys(t, db, names[1], dns.ECDSAP256SHA256)
+	insertKeys(t, db, names[2], dns.RSASHA256, dns.ECDSAP256SHA256)
+	insertDSRecord(t, db, names[0], dns.SHA1)
+	insertDSRecord(t, db, names[1], dns.SHA1)
+	insertDSRecord(t, db, names[1], dns.SHA256)
+
+	st, err := algorithmStats(context.Background(), db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Signed != 3 {
+		t.Fatalf("want 3 signed got %d", st.Signed)
+	}
+	want := map[string]float64{
+		"RSASHA1":         100.0 / 3,
+		"RSASHA256":       100.0 / 3,
+		"ECDSAP256SHA256": 200.0 / 3,
+		"Ed25519":         0,
+	}
+	for _, a := range st.Algorithms {
+		if d := a.Pct - want[a.Name]; d < -0.1 || d > 0.1 {
+			t.Errorf("%s: want %.1f got %.1f", a.Name, want[a.Name], a.Pct)
+		}
+	}
+	if d := st.SHA1Only - 100.0/3; d < -0.1 || d > 0.1 {
+		t.Errorf("sha1-only: want 33.3 got %.1f", st.SHA1Only)
+	}
+}
+
+func TestCheckDomainSignedNoDS(t *testing.T) {
+	z, _ := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	db := testDB(t)
+	ctx := context.Background()
+	for i, name := range []string{"nods.test", "plain.test", "good.test"} {
+		id := insertDomain(t, db, name, i+1)
+		if err := checkDomain(ctx, db, id, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := signedNoDSCount(ctx, db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("want 1 signed-without-DS domain, got %d", n)
+	}
+}
+
+func TestCDSEpisodes(t *testing.T) {
+	db := testDB(t)
+	names := seedDomains(t, db, 2)
+	start := time.Now().Add(-72 * time.Hour)
+	for i, status := range []string{cdsNone, cdsPending, cdsPending, cdsInSync} {
+		insertCheck(t, db, names[0], start.Add(time.Duration(i)*24*time.Hour), true)
+		if _, err := db.Exec(
+			"UPDATE dns_checks SET cds_status = ? WHERE id = last_insert_rowid()",
+			status,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertCheck(t, db, names[1], start, true)
+	if _, err := db.Exec(
+		"UPDATE dns_checks SET cds_status = ? WHERE id = last_insert_rowid()",
+		cdsPending,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	eps, err := cdsEpisodes(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eps) != 2 {
+		t.Fatalf("want 2 episodes got %d", len(eps))
+	}
+	for _, e := range eps {
+		switch e.Name {
+		case names[0]:
+			if e.Lag != 48*time.Hour {
+				t.Errorf("want 48h lag got %v", e.Lag)
+			}
+		case names[1]:
+			if !e.SyncedAt.IsZero() {
+				t.Errorf("%s should still be pending", e.Name)
+			}
+		}
+	}
+}
+
+// TestCheckDomainSignatures makes sure a zone is as close to expiry as
+// its shortest-lived apex RRset.
+func TestCheckDomainSignatures(t *testing.T) {
+	z, keys := signedTree(t)
+	good := keys["good.test."]
+	soa := mustRR(t, "good.test. 3600 IN SOA ns.good.test. host.good.test. 1 7200 3600 1209600 3600")
+	z.add(soa, testSign(t, good, time.Now().Add(2*time.Hour), soa))
+
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	db := testDB(t)
+	ctx := context.Background()
+	id := insertDomain(t, db, "good.test", 1)
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for within, want := range map[time.Duration]int{
+		time.Hour:     0,
+		3 * time.Hour: 1,
+	} {
+		n, err := expiringCount(ctx, db, within)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("within %v: want %d got %d", within, want, n)
+		}
+	}
+}
+
+func TestDenialRatios(t *testing.T) {
+	db := testDB(t)
+	names := seedDomains(t, db, 5)
+	now := time.Now()
+	for i, d := range []denial{
+		{Type: denialNSEC},
+		{Type: denialNSEC3},
+		{Type: denialNSEC3, Iterations: 5, SaltLen: 8},
+		{Type: denialCompact},
+		{}, // unsigned
+	} {
+		insertCheck(t, db, names[i], now, d.Type != "")
+		if _, err := db.Exec(`
+			UPDATE dns_checks
+			SET denial = ?, nsec3_iterations = ?, nsec3_salt_len = ?
+			WHERE id = last_insert_rowid()`,
+			d.Type, d.Iterations, d.SaltLen,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := denialRatios(context.Background(), db, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := denialStats{Signed: 4, NSEC: 25, NSEC3: 50, Compact: 25, Compliant: 50}
+	if st != want {
+		t.Fatalf("want %+v got %+v", want, st)
+	}
+}
+
+// TestCheckDomainResolverAnswers makes sure a mismatch keeps what each
+// resolver said, that it reads the same whichever resolver had DS, and that re-checking an unchanged domain replaces the
+// answers rather than piling them up.
+func TestCheckDomainResolverAnswers(t *testing.T) {
+	fastRetries(t)
+	z, _ := signedTree(t)
+	withDS := serveDNS(t, z)
+	without := serveDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		w.WriteMsg(m)
+	}))
+	usePool(t, withDS, without)
+
+	db := testDB(t)
+	ctx := context.Background()
+	id := insertDomain(t, db, "good.test", 1)
+	// the resolvers come in either order, which mustn't make it a new
+	// failure each time
+	for i := 0; i < 4; i++ {
+		if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := disagreements(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("want 1 disagreement got %d", len(list))
+	}
+	if strings.Contains(list[0].Error, withDS) || strings.Contains(list[0].Error, without) {
+		t.Errorf("resolver in error: %s", list[0].Error)
+	}
+	answers, err := loadAnswers(ctx, db, list[0].checkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("want 2 answers got %d", len(answers))
+	}
+	saw := map[string]int{}
+	for _, a := range answers {
+		if a.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: rcode %s", a.Resolver, a.RcodeName())
+		}
+		saw[a.Resolver] = len(a.Answer)
+	}
+	if saw[withDS] == 0 || saw[without] != 0 {
+		t.Errorf("answers mixed up: %v", saw)
+	}
+}
+
+// TestLookupDSAnswerFlags makes sure the stored answers show whether a
+// resolver validated DS, and whether it took a retry over TCP.
+func TestLookupDSAnswerFlags(t *testing.T) {
+	ds := mustRR(t, "good.test. 300 IN DS 12345 13 2 0123456789abcdef")
+	addr := serveDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if w.RemoteAddr().Network() == "udp" {
+			m.Truncated = true
+			w.WriteMsg(m)
+			return
+		}
+		m.Answer = append(m.Answer, ds)
+		// like a real resolver, only say AD to a client that asked for
+		// DNSSEC
+		m.AuthenticatedData = req.IsEdns0() != nil && req.IsEdns0().Do()
+		w.WriteMsg(m)
+	}))
+	usePool(t, addr, addr)
+
+	records, answers, err := lookupDS(context.Background(), "good.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("want 1 DS got %d", len(records))
+	}
+	for _, a := range answers {
+		if !a.AD || !a.TC {
+			t.Errorf("%s: want AD and TC, got %+v", a.Resolver, a)
+		}
+	}
+}
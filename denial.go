@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/miekg/dns"
+)
+
+// how a signed zone proves a name doesn't exist
+const (
+	denialNSEC     = "nsec"
+	denialNSEC3    = "nsec3"
+	denialCompact  = "compact"  // "black lies": NOERROR and an NSEC at the name itself
+	denialWildcard = "wildcard" // the random name matched a wildcard, so no proof
+	denialUnknown  = "unknown"  // signed, but no NSEC or NSEC3 came back
+)
+
+type denial struct {
+	Type       string
+	Iterations int
+	SaltLen    int
+	OptOut     bool
+}
+
+// Compliant reports whether NSEC3 parameters follow RFC 9276 (no extra
+// iterations, no salt). It only means anything for NSEC3 zones.
+func (d denial) Compliant() bool {
+	return d.Type == denialNSEC3 && d.Iterations == 0 && d.SaltLen == 0
+}
+
+func (d denial) String() string {
+	if d.Type != denialNSEC3 {
+		return d.Type
+	}
+	s := fmt.Sprintf("nsec3, %d iterations, %d byte salt", d.Iterations, d.SaltLen)
+	if d.OptOut {
+		s += ", opt-out"
+	}
+	return s
+}
+
+// probeDenial asks for a random name under a signed domain and looks at
+// how the zone says no.
+func probeDenial(ctx context.Context, domain string) (denial, error) {
+	qname := fmt.Sprintf("dnssecmenot-%x.%s", rand.Uint64(), dns.Fqdn(domain))
+	r, err := query(ctx, pool.one(), qname, dns.TypeA)
+	if err != nil {
+		return denial{}, err
+	}
+	switch r.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		return denial{}, rcodeError("denial", r.Rcode)
+	}
+	return classifyDenial(r, qname), nil
+}
+
+func classifyDenial(r *dns.Msg, qname string) denial {
+	if len(r.Answer) > 0 {
+		return denial{Type: denialWildcard}
+	}
+
+	d := denial{Type: denialUnknown}
+	for _, rr := range r.Ns {
+		switch v := rr.(type) {
+		case *dns.NSEC3:
+			d.Type = denialNSEC3
+			d.Iterations = int(v.Iterations)
+			d.SaltLen = int(v.SaltLength)
+			d.OptOut = v.Flags&1 != 0
+			return d
+		case *dns.NSEC:
+			if r.Rcode == dns.RcodeSuccess &&
+				dns.CanonicalName(v.Hdr.Name) == dns.CanonicalName(qname) {
+				return denial{Type: denialCompact}
+			}
+			d.Type = denialNSEC
+		}
+	}
+	return d
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestClassifyDenial(t *testing.T) {
+	const qname = "dnssecmenot-1.example.test."
+	msg := func(rcode int, answer []dns.RR, ns ...dns.RR) *dns.Msg {
+		m := new(dns.Msg)
+		m.Rcode = rcode
+		m.Answer = answer
+		m.Ns = ns
+		return m
+	}
+
+	for _, tc := range []struct {
+		name string
+		msg  *dns.Msg
+		want denial
+	}{
+		{
+			"nsec",
+			msg(dns.RcodeNameError, nil, mustRR(t, "a.example.test. 300 IN NSEC z.example.test. A RRSIG NSEC")),
+			denial{Type: denialNSEC},
+		},
+		{
+			"compact",
+			msg(dns.RcodeSuccess, nil, mustRR(t, qname+" 300 IN NSEC \\000."+qname+" RRSIG NSEC NXNAME")),
+			denial{Type: denialCompact},
+		},
+		{
+			"nsec3 per rfc 9276",
+			msg(dns.RcodeNameError, nil, mustRR(t, "abc.example.test. 300 IN NSEC3 1 0 0 - DEF A RRSIG")),
+			denial{Type: denialNSEC3},
+		},
+		{
+			"nsec3 salted opt-out",
+			msg(dns.RcodeNameError, nil, mustRR(t, "abc.example.test. 300 IN NSEC3 1 1 10 AABBCCDD DEF A RRSIG")),
+			denial{Type: denialNSEC3, Iterations: 10, SaltLen: 4, OptOut: true},
+		},
+		{
+			"wildcard",
+			msg(dns.RcodeSuccess, []dns.RR{mustRR(t, qname+" 300 IN A 192.0.2.1")}),
+			denial{Type: denialWildcard},
+		},
+		{
+			"nothing",
+			msg(dns.RcodeNameError, nil),
+			denial{Type: denialUnknown},
+		},
+	} {
+		got := classifyDenial(tc.msg, qname)
+		if got != tc.want {
+			t.Errorf("%s: want %+v got %+v", tc.name, tc.want, got)
+		}
+		if got.Compliant() != (tc.name == "nsec3 per rfc 9276") {
+			t.Errorf("%s: compliant %v", tc.name, got.Compliant())
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// diagnose runs every probe against name and writes a report of what the
+// checker sees to w. With db, the result is also recorded the way the
+// scheduler would, if the domain is one we track.
+func diagnose(ctx context.Context, db *sql.DB, name string, w io.Writer) error {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	res := probeDomain(ctx, name)
+	writeReport(w, name, res)
+
+	if db == nil {
+		return nil
+	}
+	var id int
+	err := db.QueryRowContext(ctx,
+		`SELECT id FROM domains WHERE name = ?`, name,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s isn't in the domain list, not recording", name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := recordCheck(ctx, db, id, name, res); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "\nrecorded in dns_checks")
+	return nil
+}
+
+func writeReport(w io.Writer, name string, res *checkResult) {
+	fmt.Fprintf(w, "%s: %s\n", name, statusLabel(res))
+	if iterativeMode {
+		fmt.Fprintln(w, "  DS from the parent's servers (DNS_MODE=iterative)")
+	}
+	if res.Err != "" {
+		fmt.Fprintf(w, "  error (%s): %s\n", res.ErrCode, res.Err)
+	}
+	for _, p := range slices.Sorted(maps.Keys(res.ProbeErrs)) {
+		fmt.Fprintf(w, "  %s lookup failed: %s\n", p, res.ProbeErrs[p])
+	}
+
+	section(w, "Resolver answers for DS")
+	for _, a := range res.Answers {
+		status := a.RcodeName()
+		if a.Err != "" {
+			status = a.Err
+		}
+		if a.AD {
+			status += " ad"
+		}
+		if a.TC {
+			status += " tc"
+		}
+		fmt.Fprintf(w, "  %s: %s, %s\n", a.Resolver, status, a.RTT.Round(time.Millisecond))
+		for _, rr := range a.Answer {
+			fmt.Fprintf(w, "    %s\n", rr)
+		}
+	}
+
+	section(w, "DS")
+	for _, d := range res.DS {
+		fmt.Fprintf(w, "  %d %s %s %s (ttl %d)\n", d.KeyTag,
+			algName(d.Algorithm), digestName(d.DigestType), d.Digest, d.TTL)
+	}
+	if len(res.DS) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+
+	section(w, "DNSKEY")
+	for _, k := range res.DNSKEY {
+		role := "ZSK"
+		if k.SEP() {
+			role = "KSK"
+		}
+		fmt.Fprintf(w, "  %d %s %s (flags %d, ttl %d)\n",
+			k.KeyTag, role, algName(k.Algorithm), k.Flags, k.TTL)
+	}
+	if len(res.DNSKEY) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+
+	if len(res.Sigs) > 0 {
+		section(w, "Apex signatures")
+		for _, s := range res.Sigs {
+			fmt.Fprintf(w, "  %s by %d, expires %s (in %s)\n",
+				dns.TypeToString[s.TypeCovered], s.KeyTag,
+				s.Expiration.Format(time.DateTime),
+				time.Until(s.Expiration).Round(time.Minute))
+		}
+	}
+
+	if res.Denial.Type != "" {
+		section(w, "Denial of existence")
+		fmt.Fprintf(w, "  %s\n", res.Denial)
+		if res.Denial.Type == denialNSEC3 {
+			fmt.Fprintf(w, "  RFC 9276 compliant: %v\n", res.Denial.Compliant())
+		}
+	}
+
+	if len(res.Nameservers) > 0 {
+		section(w, "Nameservers")
+		fmt.Fprintf(w, "  provider: %s\n", res.Provider)
+		for _, h := range res.Nameservers {
+			fmt.Fprintf(w, "  %s\n", h)
+		}
+	}
+
+	if len(res.NS) > 0 {
+		section(w, "DNSKEY and SOA from each nameserver")
+		for _, a := range res.NS {
+			if a.Err != "" {
+				fmt.Fprintf(w, "  %s: %s\n", a.label(), a.Err)
+				continue
+			}
+			signed, serial := "signed", "no SOA"
+			if !a.Signed {
+				signed = "unsigned"
+			}
+			if a.Serial >= 0 {
+				serial = fmt.Sprintf("serial %d", a.Serial)
+			}
+			fmt.Fprintf(w, "  %s: DNSKEY %v, %s, %s\n",
+				a.label(), a.KeyTags, signed, serial)
+		}
+		if res.NSProblem != "" {
+			fmt.Fprintf(w, "  inconsistent: %s\n", res.NSProblem)
+		}
+		if serialSkew(res.NS) {
+			fmt.Fprintln(w, "  SOA serials differ")
+		}
+	}
+
+	if len(res.CDS) > 0 || len(res.CDNSKEY) > 0 {
+		section(w, "CDS/CDNSKEY")
+		fmt.Fprintf(w, "  %s\n", res.CDSStatus)
+		for _, d := range res.CDS {
+			fmt.Fprintf(w, "  CDS %d %s %s\n", d.KeyTag,
+				algName(d.Algorithm), digestName(d.DigestType))
+		}
+		for _, k := range res.CDNSKEY {
+			fmt.Fprintf(w, "  CDNSKEY %d %s\n", k.KeyTag, algName(k.Algorithm))
+		}
+	}
+
+	if res.DANE != "" {
+		section(w, "Mail")
+		fmt.Fprintf(w, "  DANE: %s\n", res.DANE)
+		for _, a := range res.MX {
+			signed := "signed"
+			if !a.ZoneSigned {
+				signed = "unsigned"
+			}
+			fmt.Fprintf(w, "  %d %s (%s %s)\n", a.Preference, a.Host, a.Zone, signed)
+			if a.Err != "" {
+				fmt.Fprintf(w, "    %s\n", a.Err)
+			}
+			for _, t := range a.TLSA {
+				fmt.Fprintf(w, "    TLSA %s\n", t)
+			}
+		}
+	}
+
+	if res.Val.Verdict != "" {
+		section(w, "Validation")
+		fmt.Fprintf(w, "  %s", res.Val.Verdict)
+		if res.Val.Reason != "" {
+			fmt.Fprintf(w, ": %s", res.Val.Reason)
+		}
+		fmt.Fprintln(w)
+		for _, s := range res.Val.Path {
+			fmt.Fprintf(w, "    %s\n", s)
+		}
+	}
+}
+
+func section(w io.Writer, title string) {
+	fmt.Fprintf(w, "\n%s\n", title)
+}
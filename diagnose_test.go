@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	z, keys := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	if err := diagnose(ctx, nil, "Good.Test.", &out); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, want := range []string{
+		"good.test: enabled",
+		addr + ": NOERROR",
+		"KSK ECDSAP256SHA256",
+		"Validation\n  secure",
+		"test. DS: 1 records, signed by .",
+		"good.test. DNSKEY: 1 keys",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report missing %q:\n%s", want, report)
+		}
+	}
+	if !strings.Contains(report, strings.ToUpper(keys["good.test."].ds().Digest)) {
+		t.Errorf("report missing the DS digest:\n%s", report)
+	}
+
+	// recording needs a tracked domain
+	db := testDB(t)
+	if err := diagnose(ctx, db, "good.test", &out); err == nil {
+		t.Error("recorded an untracked domain")
+	}
+	id := insertDomain(t, db, "good.test", 1)
+	if err := diagnose(ctx, db, "good.test", &out); err != nil {
+		t.Fatal(err)
+	}
+	_, last, err := lastCheck(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || !last.HasDNSSEC || last.Val.Verdict != verdictSecure {
+		t.Errorf("recorded %+v", last)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// what keyEvents can find between two checks, stored as key_events.type
+const (
+	eventDSAdded           = "ds-added"
+	eventDSRemoved         = "ds-removed"
+	eventKSKRollover       = "ksk-rollover"
+	eventZSKRollover       = "zsk-rollover"
+	eventAlgorithmRollover = "algorithm-rollover"
+	eventEmergency         = "emergency-key-change" // every KSK swapped at once
+)
+
+var eventLabels = map[string]string{
+	eventDSAdded:           "DS added",
+	eventDSRemoved:         "DS removed",
+	eventKSKRollover:       "KSK rollover",
+	eventZSKRollover:       "ZSK rollover",
+	eventAlgorithmRollover: "algorithm rollover",
+	eventEmergency:         "emergency key change",
+}
+
+// keyEvent is one thing that happened to a domain's DS or DNSKEY set
+// between two checks.
+type keyEvent struct {
+	Type   string
+	Detail string
+}
+
+// keyEvents compares the DS and DNSKEY sets of two successful checks. DS
+// records are reported one by one. Key changes only count for a zone
+// that had keys both times; a zone starting or stopping signing shows up
+// as a status change instead. A change of algorithms is reported as
+// such, and not also as the KSK and ZSK changes it took.
+func keyEvents(oldDS []dsRecord, oldKeys []dnskeyRecord, ds []dsRecord, keys []dnskeyRecord) []keyEvent {
+	var ret []keyEvent
+
+	for _, d := range ds {
+		if !slices.ContainsFunc(oldDS, d.sameAs) {
+			ret = append(ret, keyEvent{eventDSAdded, d.label()})
+		}
+	}
+	for _, d := range oldDS {
+		if !slices.ContainsFunc(ds, d.sameAs) {
+			ret = append(ret, keyEvent{eventDSRemoved, d.label()})
+		}
+	}
+
+	if len(oldKeys) == 0 || len(keys) == 0 {
+		return ret
+	}
+
+	if before, after := keyAlgorithms(oldKeys), keyAlgorithms(keys); !slices.Equal(before, after) {
+		return append(ret, keyEvent{eventAlgorithmRollover,
+			algList(before) + " → " + algList(after)})
+	}
+
+	oldKSK, oldZSK := splitKeys(oldKeys)
+	ksk, zsk := splitKeys(keys)
+	if added, removed := diffKeys(oldKSK, ksk); len(added)+len(removed) > 0 {
+		typ := eventKSKRollover
+		if len(removed) == len(oldKSK) && len(added) == len(ksk) &&
+			len(oldKSK) > 0 && len(ksk) > 0 {
+			// no overlap, so the parent's DS can't have kept up
+			typ = eventEmergency
+		}
+		ret = append(ret, keyEvent{typ, keyChange(added, removed)})
+	}
+	if added, removed := diffKeys(oldZSK, zsk); len(added)+len(removed) > 0 {
+		ret = append(ret, keyEvent{eventZSKRollover, keyChange(added, removed)})
+	}
+	return ret
+}
+
+func (r dsRecord) sameAs(o dsRecord) bool {
+	return r.KeyTag == o.KeyTag && r.key() == o.key()
+}
+
+func (r dsRecord) label() string {
+	return fmt.Sprintf("%d %s %s", r.KeyTag, algName(r.Algorithm), digestName(r.DigestType))
+}
+
+func (r dnskeyRecord) sameAs(o dnskeyRecord) bool {
+	return r.Algorithm == o.Algorithm && r.PublicKey == o.PublicKey
+}
+
+func keyAlgorithms(keys []dnskeyRecord) []uint8 {
+	var ret []uint8
+	for _, k := range keys {
+		ret = append(ret, k.Algorithm)
+	}
+	slices.Sort(ret)
+	return slices.Compact(ret)
+}
+
+func algList(algs []uint8) string {
+	var names []string
+	for _, a := range algs {
+		names = append(names, algName(a))
+	}
+	return strings.Join(names, ", ")
+}
+
+// splitKeys goes by the SEP flag, which is how zones mark their KSKs. A
+// zone signing with one combined key has no ZSKs.
+func splitKeys(keys []dnskeyRecord) (ksk, zsk []dnskeyRecord) {
+	for _, k := range keys {
+		if k.SEP() {
+			ksk = append(ksk, k)
+		} else {
+			zsk = append(zsk, k)
+		}
+	}
+	return
+}
+
+func diffKeys(before, after []dnskeyRecord) (added, removed []dnskeyRecord) {
+	for _, k := range after {
+		if !slices.ContainsFunc(before, k.sameAs) {
+			added = append(added, k)
+		}
+	}
+	for _, k := range before {
+		if !slices.ContainsFunc(after, k.sameAs) {
+			removed = append(removed, k)
+		}
+	}
+	return
+}
+
+func keyChange(added, removed []dnskeyRecord) string {
+	var parts []string
+	for _, k := range added {
+		parts = append(parts, fmt.Sprintf("+%d", k.KeyTag))
+	}
+	for _, k := range removed {
+		parts = append(parts, fmt.Sprintf("-%d", k.KeyTag))
+	}
+	return strings.Join(parts, " ")
+}
+
+// prevKeys loads the DS and DNSKEY sets from the domain's last check
+// that didn't fail, since a failed one doesn't know what the sets were.
+func prevKeys(ctx context.Context, db *sql.DB, domainID int) ([]dsRecord, []dnskeyRecord, bool, error) {
+	var id int
+	err := db.QueryRowContext(ctx, `
+		SELECT id FROM dns_checks
+		WHERE domain_id = ? AND COALESCE(error, '') = ''
+		ORDER BY checked_at DESC, id DESC
+		LIMIT 1`,
+		domainID,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	ds, err := loadDS(ctx, db, "ds_records", id)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	keys, err := loadDNSKEY(ctx, db, "dnskey_records", id)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return ds, keys, true, nil
+}
+
+func insertEvents(ctx context.Context, tx *sql.Tx, domainID int, checkID int64, events []keyEvent) error {
+	for _, e := range events {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO key_events(domain_id, check_id, type, detail)
+			VALUES(?, ?, ?, ?)`,
+			domainID, checkID, e.Type, e.Detail,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type eventRow struct {
+	Name       string
+	Type       string
+	Detail     string
+	SeenAt     string
+	SeenAtTime time.Time
+}
+
+func (e eventRow) Label() string {
+	if l, ok := eventLabels[e.Type]; ok {
+		return l
+	}
+	return e.Type
+}
+
+// Alarming is for the events that tend to come before an outage.
+func (e eventRow) Alarming() bool {
+	return e.Type == eventEmergency
+}
+
+func recentEvents(ctx context.Context, db *sql.DB, limit int) ([]eventRow, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.name, e.type, e.detail, e.created_at
+		FROM key_events e
+		JOIN domains d ON d.id = e.domain_id
+		ORDER BY e.created_at DESC, e.id DESC
+		LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []eventRow
+	for rows.Next() {
+		var e eventRow
+		if err := rows.Scan(&e.Name, &e.Type, &e.Detail, &e.SeenAtTime); err != nil {
+			return nil, err
+		}
+		e.SeenAt = e.SeenAtTime.Format("2006-01-02 15:04")
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
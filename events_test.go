@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestKeyEvents(t *testing.T) {
+	key := func(tag uint16, flags uint16, alg uint8) dnskeyRecord {
+		return dnskeyRecord{KeyTag: tag, Flags: flags, Protocol: 3,
+			Algorithm: alg, PublicKey: string(rune('A' + tag))}
+	}
+	ds := func(tag uint16) dsRecord {
+		return dsRecord{KeyTag: tag, Algorithm: dns.ECDSAP256SHA256,
+			DigestType: dns.SHA256, Digest: string(rune('A' + tag))}
+	}
+	var (
+		k1 = key(1, 257, dns.ECDSAP256SHA256)
+		k2 = key(2, 257, dns.ECDSAP256SHA256)
+		z1 = key(3, 256, dns.ECDSAP256SHA256)
+		z2 = key(4, 256, dns.ECDSAP256SHA256)
+		r1 = key(5, 257, dns.RSASHA256)
+		r2 = key(6, 256, dns.RSASHA256)
+	)
+	for _, tc := range []struct {
+		name          string
+		oldDS, newDS  []dsRecord
+		oldKeys, keys []dnskeyRecord
+		want          []keyEvent
+	}{
+		{"nothing", []dsRecord{ds(1)}, []dsRecord{ds(1)},
+			[]dnskeyRecord{k1, z1}, []dnskeyRecord{k1, z1}, nil},
+		{"signing starts", nil, []dsRecord{ds(1)},
+			nil, []dnskeyRecord{k1, z1},
+			[]keyEvent{{eventDSAdded, "1 ECDSAP256SHA256 SHA256"}}},
+		{"ksk pre-published", []dsRecord{ds(1)}, []dsRecord{ds(1), ds(2)},
+			[]dnskeyRecord{k1, z1}, []dnskeyRecord{k1, k2, z1},
+			[]keyEvent{
+				{eventDSAdded, "2 ECDSAP256SHA256 SHA256"},
+				{eventKSKRollover, "+2"},
+			}},
+		{"ksk retired", []dsRecord{ds(1), ds(2)}, []dsRecord{ds(2)},
+			[]dnskeyRecord{k1, k2, z1}, []dnskeyRecord{k2, z1},
+			[]keyEvent{
+				{eventDSRemoved, "1 ECDSAP256SHA256 SHA256"},
+				{eventKSKRollover, "-1"},
+			}},
+		{"ksk swapped", []dsRecord{ds(1)}, []dsRecord{ds(1)},
+			[]dnskeyRecord{k1, z1}, []dnskeyRecord{k2, z1},
+			[]keyEvent{{eventEmergency, "+2 -1"}}},
+		{"zsk", nil, nil,
+			[]dnskeyRecord{k1, z1}, []dnskeyRecord{k1, z2},
+			[]keyEvent{{eventZSKRollover, "+4 -3"}}},
+		{"algorithm", nil, nil,
+			[]dnskeyRecord{r1, r2}, []dnskeyRecord{r1, r2, k1, z1},
+			[]keyEvent{{eventAlgorithmRollover, "RSASHA256 → RSASHA256, ECDSAP256SHA256"}}},
+	} {
+		got := keyEvents(tc.oldDS, tc.oldKeys, tc.newDS, tc.keys)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %v got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestRecordCheckEvents(t *testing.T) {
+	db := testDB(t)
+	id := insertDomain(t, db, "good.test", 1)
+	ctx := context.Background()
+
+	var (
+		k1 = dnskeyRecord{KeyTag: 1, Flags: 257, Protocol: 3,
+			Algorithm: dns.ECDSAP256SHA256, PublicKey: "one"}
+		k2 = dnskeyRecord{KeyTag: 2, Flags: 257, Protocol: 3,
+			Algorithm: dns.ECDSAP256SHA256, PublicKey: "two"}
+		ds = []dsRecord{{KeyTag: 1, Algorithm: dns.ECDSAP256SHA256,
+			DigestType: dns.SHA256, Digest: "AA"}}
+	)
+	for _, res := range []*checkResult{
+		{HasDNSSEC: true, HasDNSKEY: true, DS: ds, DNSKEY: []dnskeyRecord{k1}},
+		// a failed check has no keys, but nothing happened to them
+		{Err: "timeout", ErrCode: failTimeout},
+		{HasDNSSEC: true, HasDNSKEY: true, DS: ds, DNSKEY: []dnskeyRecord{k1, k2}},
+	} {
+		if err := recordCheck(ctx, db, id, "good.test", res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := recentEvents(ctx, db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("want one event, got %+v", events)
+	}
+	if e := events[0]; e.Name != "good.test" || e.Type != eventKSKRollover ||
+		e.Detail != "+2" || e.Label() != "KSK rollover" {
+		t.Errorf("event %+v", e)
+	}
+}
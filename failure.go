@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// failure classes, stored in dns_checks.error_code so failures can be
+// counted by kind; the error column keeps the details
+const (
+	failNone      = ""
+	failTimeout   = "timeout"
+	failServfail  = "servfail"
+	failRefused   = "refused"
+	failNXDomain  = "nxdomain"
+	failTruncated = "truncated" // truncated over UDP and the TCP retry failed
+	failNetwork   = "network"
+	failMismatch  = "mismatch" // resolvers disagreed about DS
+	failBogus     = "bogus"    // SERVFAIL from a validating resolver, fine with CD set
+	failOther     = "other"
+)
+
+var failureCodes = []string{
+	failTimeout, failServfail, failRefused, failNXDomain,
+	failTruncated, failNetwork, failMismatch, failBogus, failOther,
+}
+
+// lookupError is an error we already know the class of.
+type lookupError struct {
+	Code string
+	Err  error
+}
+
+func (e *lookupError) Error() string { return e.Err.Error() }
+func (e *lookupError) Unwrap() error { return e.Err }
+
+func failf(code, format string, args ...any) error {
+	return &lookupError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// rcodeError turns an rcode that means "no answer" into an error.
+func rcodeError(what string, rcode int) error {
+	code := failOther
+	switch rcode {
+	case dns.RcodeServerFailure:
+		code = failServfail
+	case dns.RcodeRefused:
+		code = failRefused
+	case dns.RcodeNameError:
+		code = failNXDomain
+	}
+	return failf(code, "%s: %s", what, dns.RcodeToString[rcode])
+}
+
+// classify picks the failure class for err.
+func classify(err error) string {
+	if err == nil {
+		return failNone
+	}
+	var le *lookupError
+	if errors.As(err, &le) {
+		return le.Code
+	}
+	if isTimeout(err) {
+		return failTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return failNetwork
+	}
+	return failOther
+}
+
+// retryable failures are the ones a second try (with a fresh pair of
+// resolvers) might fix. NXDOMAIN is an answer, just not a useful one, and
+// bogus data stays bogus.
+func retryable(err error) bool {
+	switch classify(err) {
+	case failNXDomain, failBogus, failOther:
+		return false
+	}
+	return true
+}
+
+var (
+	dsAttempts = 3
+	dsBackoff  = 500 * time.Millisecond // doubles each retry, plus jitter
+)
+
+// withRetry runs fn until it succeeds, fails for good, or runs out of
+// attempts, backing off exponentially in between.
+func withRetry(ctx context.Context, what string, fn func() error) error {
+	var (
+		err   error
+		delay = dsBackoff
+	)
+	for i := 0; i < dsAttempts; i++ {
+		if i > 0 {
+			slog.Info("retrying", "lookup", what, "attempt", i+1, "err", err)
+			jitter := time.Duration(rand.Int64N(int64(delay)/2 + 1))
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay + jitter):
+			}
+			delay *= 2
+		}
+		if err = fn(); err == nil || !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fastRetries keeps retry backoff from slowing the tests down.
+func fastRetries(t *testing.T) {
+	t.Helper()
+	old := dsBackoff
+	dsBackoff = time.Millisecond
+	t.Cleanup(func() { dsBackoff = old })
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, failNone},
+		{rcodeError("ds", dns.RcodeServerFailure), failServfail},
+		{rcodeError("ds", dns.RcodeRefused), failRefused},
+		{rcodeError("ds", dns.RcodeNameError), failNXDomain},
+		{failf(failMismatch, "mismatch"), failMismatch},
+		{fmt.Errorf("two lookups: %w", errors.Join(nil, os.ErrDeadlineExceeded)), failTimeout},
+		{fmt.Errorf("x: %w", context.DeadlineExceeded), failTimeout},
+		{errors.New("dns: bad rdata"), failOther},
+	} {
+		if got := classify(tc.err); got != tc.want {
+			t.Errorf("%v: want %q got %q", tc.err, tc.want, got)
+		}
+	}
+}
+
+// flaky answers SERVFAIL to the first n queries, then hands off to h.
+type flaky struct {
+	n int32
+	h dns.Handler
+}
+
+func (f *flaky) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if atomic.AddInt32(&f.n, -1) >= 0 {
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+		return
+	}
+	f.h.ServeDNS(w, req)
+}
+
+func TestLookupDSRetries(t *testing.T) {
+	fastRetries(t)
+	z, keys := signedTree(t)
+	ctx := context.Background()
+
+	// one SERVFAIL per resolver pair is survivable
+	addr := serveDNS(t, &flaky{n: 1, h: z})
+	usePool(t, addr, addr)
+	rrs, _, err := lookupDS(ctx, "good.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameDS(dsRecords(rrs), dsRecords([]dns.RR{keys["good.test."].ds()})) {
+		t.Errorf("wrong DS after retry: %v", rrs)
+	}
+
+	// a resolver that never recovers fails with the right code
+	addr = serveDNS(t, &flaky{n: 1 << 20, h: z})
+	usePool(t, addr, addr)
+	db := testDB(t)
+	id := insertDomain(t, db, "good.test", 1)
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+	_, last, err := lastCheck(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.ErrCode != failServfail {
+		t.Errorf("want %q got %q (%s)", failServfail, last.ErrCode, last.Err)
+	}
+
+	counts, err := failureCounts(ctx, db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[0] != (failureCount{failServfail, 1}) {
+		t.Errorf("failure counts: %+v", counts)
+	}
+}
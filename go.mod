@@ -5,12 +5,14 @@ go 1.24.2
 require (
 	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/miekg/dns v1.1.66
+	golang.org/x/net v0.39.0
+	golang.org/x/time v0.11.0
 )
 
 require (
 	golang.org/x/mod v0.24.0 // indirect
-	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/text v0.24.0 // indirect
 	golang.org/x/tools v0.32.0 // indirect
 )
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type cdsStats struct {
+	Published int // publishes CDS or CDNSKEY at all
+	InSync    int
+	Pending   int // includes pending deletions
+}
+
+// cdsCounts tallies the latest CDS state of the top-N domains.
+func cdsCounts(ctx context.Context, db *sql.DB, limit int) (cdsStats, error) {
+	var st cdsStats
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+		       COALESCE(SUM(c.cds_status = ?), 0),
+		       COALESCE(SUM(c.cds_status IN (?, ?)), 0)
+		FROM domains d
+		JOIN dns_checks c ON c.id = (
+			SELECT id FROM dns_checks dc
+			WHERE dc.domain_id = d.id
+			ORDER BY dc.checked_at DESC LIMIT 1
+		)
+		WHERE d.rank <= ? AND COALESCE(c.cds_status, '') != ''`,
+		cdsInSync, cdsPending, cdsDeletePending, limit,
+	).Scan(&st.Published, &st.InSync, &st.Pending)
+	return st, err
+}
+
+// cdsEpisode is one stretch of a child asking for DS the parent hadn't
+// published yet, and (if it's over) how long the parent took.
+type cdsEpisode struct {
+	Name         string
+	PendingSince time.Time
+	SyncedAt     time.Time
+	Lag          time.Duration
+}
+
+func cdsEpisodes(ctx context.Context, db *sql.DB) ([]cdsEpisode, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH
+		-- errors don't tell us anything about CDS either way
+		seen AS (
+			SELECT domain_id, checked_at, cds_status,
+			       COALESCE(created_at, checked_at) AS first_seen,
+			       LAG(cds_status) OVER (
+			           PARTITION BY domain_id
+			           ORDER BY checked_at
+			       ) AS prev
+			FROM dns_checks
+			WHERE error IS NULL OR error = ''
+		)
+		SELECT d.name, p.first_seen, (
+			SELECT MIN(x.first_seen)
+			FROM seen x
+			WHERE x.domain_id = p.domain_id
+			AND x.checked_at > p.checked_at
+			AND x.cds_status = ?
+		)
+		FROM seen p
+		JOIN domains d ON d.id = p.domain_id
+		WHERE p.cds_status = ?
+		AND COALESCE(p.prev, '') != ?
+		ORDER BY p.first_seen DESC
+		LIMIT 200`,
+		cdsInSync, cdsPending, cdsPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []cdsEpisode
+	for rows.Next() {
+		var (
+			e      cdsEpisode
+			since  string
+			synced sql.NullString
+		)
+		if err := rows.Scan(&e.Name, &since, &synced); err != nil {
+			return nil, err
+		}
+		if e.PendingSince, err = parseTimestamp(since); err != nil {
+			return nil, err
+		}
+		if synced.Valid {
+			if e.SyncedAt, err = parseTimestamp(synced.String); err != nil {
+				return nil, err
+			}
+			e.Lag = e.SyncedAt.Sub(e.PendingSince)
+		}
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
+
+func (srv *DNSSECMeNot) handleCDS(w http.ResponseWriter, r *http.Request) {
+	st, err1 := cdsCounts(r.Context(), srv.db, 1000)
+	episodes, err2 := cdsEpisodes(r.Context(), srv.db)
+	if err := errors.Join(err1, err2); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		total time.Duration
+		done  int
+	)
+	for _, e := range episodes {
+		if !e.SyncedAt.IsZero() {
+			total += e.Lag
+			done++
+		}
+	}
+
+	data := struct {
+		Stats    cdsStats
+		Episodes []cdsEpisode
+		AvgLag   time.Duration
+	}{
+		Stats:    st,
+		Episodes: episodes,
+	}
+	if done > 0 {
+		data.AvgLag = total / time.Duration(done)
+	}
+	if err := templates.ExecuteTemplate(w, "cds", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
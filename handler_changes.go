@@ -1,39 +1,80 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"net/http"
 	"time"
 )
 
 type changeRow struct {
+	checkID       int
 	Name          string
-	HasDNSSEC     bool
+	Status        string
+	Prev          string // the status before this change
+	HasDNSKEY     bool
+	Error         string
+	Answers       []resolverAnswer
 	CheckedAt     string
 	CheckedAtTime time.Time
 }
 
+// disagreements returns the latest checks where the two resolvers didn't
+// agree on whether there's a DS set.
+func disagreements(ctx context.Context, db *sql.DB) ([]changeRow, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.id, d.name, c.error, c.checked_at
+		FROM dns_checks c
+		JOIN domains d ON d.id = c.domain_id
+		WHERE c.error_code = ?
+		ORDER BY c.checked_at DESC
+		LIMIT 50`,
+		failMismatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []changeRow
+	for rows.Next() {
+		var rec changeRow
+		if err := rows.Scan(
+			&rec.checkID, &rec.Name, &rec.Error, &rec.CheckedAtTime,
+		); err != nil {
+			return nil, err
+		}
+		rec.CheckedAt = rec.CheckedAtTime.Format("2006-01-02 15:04")
+		list = append(list, rec)
+	}
+	return list, rows.Err()
+}
+
 func (srv *DNSSECMeNot) handleChanges(w http.ResponseWriter, r *http.Request) {
-	rows, err := srv.db.Query(`
+	ctx := r.Context()
+	rows, err := srv.db.QueryContext(ctx, `
 		WITH
-		-- strip errors out; we'll do something with them later
+		-- a failed check isn't a change, just a gap; compare each known
+		-- status with the last known one before it
 		filtered_checks AS (
     		SELECT *
       		FROM dns_checks
-        	WHERE error IS NULL OR error = ''
+        	WHERE status != 'unknown'
          ),
         -- generate rows of name, status, last-status
         checks_with_lag AS (
-        	SELECT domain_id, checked_at, has_dnssec,
-         	LAG(has_dnssec) OVER (
+        	SELECT id, domain_id, checked_at, status, has_dnskey,
+         	LAG(status) OVER (
             	PARTITION BY domain_id
              	ORDER BY checked_at
             ) AS prev
             FROM filtered_checks
         )
-        SELECT d.name, c.checked_at, c.has_dnssec
+        SELECT c.id, d.name, c.checked_at, c.status, c.prev,
+               COALESCE(c.has_dnskey, 0)
         FROM checks_with_lag c
         JOIN domains d ON d.id = c.domain_id
-        WHERE c.prev != c.has_dnssec
+        WHERE c.prev != c.status
         ORDER BY c.checked_at DESC
         LIMIT 200`)
 	if err != nil {
@@ -45,7 +86,10 @@ func (srv *DNSSECMeNot) handleChanges(w http.ResponseWriter, r *http.Request) {
 	list := make([]changeRow, 0, 64)
 	for rows.Next() {
 		var rec changeRow
-		if err := rows.Scan(&rec.Name, &rec.CheckedAtTime, &rec.HasDNSSEC); err != nil {
+		if err := rows.Scan(
+			&rec.checkID, &rec.Name, &rec.CheckedAtTime, &rec.Status,
+			&rec.Prev, &rec.HasDNSKEY,
+		); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -56,8 +100,38 @@ func (srv *DNSSECMeNot) handleChanges(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	rows.Close()
 
-	data := struct{ Changes []changeRow }{Changes: list}
+	mismatches, err := disagreements(ctx, srv.db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	events, err := recentEvents(ctx, srv.db, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, l := range [][]changeRow{list, mismatches} {
+		for i := range l {
+			l[i].Answers, err = loadAnswers(ctx, srv.db, l[i].checkID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	data := struct {
+		Changes       []changeRow
+		Events        []eventRow
+		Disagreements []changeRow
+	}{
+		Changes:       list,
+		Events:        events,
+		Disagreements: mismatches,
+	}
 	if err := templates.ExecuteTemplate(w, "changes", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// how much history the detail page shows
+const historyLen = 50
+
+type checkRow struct {
+	ID            int
+	Status        string
+	HasDNSKEY     bool
+	Validation    string
+	Reason        string
+	Error         string
+	ErrorCode     string
+	EDE           *ede
+	CDSStatus     string
+	Denial        denial
+	NSProblem     string
+	Provider      string
+	DANE          string
+	ProbeErrors   string
+	DS            []dsRecord
+	CheckedAt     string
+	CheckedAtTime time.Time
+}
+
+func (srv *DNSSECMeNot) handleDomain(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx        = r.Context()
+		rec        domainRow
+		class      sql.NullString
+		suffix     sql.NullString
+		registered sql.NullString
+	)
+	rec.Name = r.URL.Query().Get("name")
+
+	err := srv.db.QueryRowContext(ctx,
+		`SELECT rank, class, public_suffix, registrable
+		FROM domains WHERE name = ?`,
+		rec.Name,
+	).Scan(&rec.Rank, &class, &suffix, &registered)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rec.Base, rec.TLD = domainParts(rec.Name, suffix.String)
+	rec.Important = isImportantTLD(rec.TLD)
+	rec.Class = class.String
+	rec.Registrable = registered.String
+
+	rows, err := srv.db.QueryContext(ctx, `
+		SELECT c.id, c.status, c.has_dnskey, c.validation,
+               c.validation_reason, c.error, c.error_code, c.cds_status,
+               c.denial, c.nsec3_iterations, c.nsec3_salt_len,
+               c.nsec3_opt_out, c.ede_code, c.ede_text, c.ns_problem,
+               c.provider, c.dane, c.probe_errors, c.checked_at
+        FROM dns_checks c
+        JOIN domains d ON d.id = c.domain_id
+        WHERE d.name = ?
+        ORDER BY c.checked_at DESC
+        LIMIT ?`,
+		rec.Name, historyLen,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	checks := make([]checkRow, 0, historyLen)
+	for rows.Next() {
+		var (
+			c                 checkRow
+			keys              sql.NullBool
+			status            sql.NullString
+			val, why, errText sql.NullString
+			errCode           sql.NullString
+			cds, den          sql.NullString
+			iters, salt       sql.NullInt64
+			optOut            sql.NullBool
+			edeCode           sql.NullInt64
+			edeText           sql.NullString
+			nsProb, prov      sql.NullString
+			dane, probeErrs   sql.NullString
+		)
+		if err := rows.Scan(
+			&c.ID, &status, &keys, &val, &why, &errText, &errCode, &cds,
+			&den, &iters, &salt, &optOut, &edeCode, &edeText,
+			&nsProb, &prov, &dane, &probeErrs, &c.CheckedAtTime,
+		); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.Status = status.String
+		c.HasDNSKEY = keys.Valid && keys.Bool
+		c.Validation = val.String
+		c.Reason = why.String
+		c.Error = errText.String
+		c.ErrorCode = errCode.String
+		c.CDSStatus = cds.String
+		c.NSProblem = nsProb.String
+		c.Provider = prov.String
+		c.DANE = dane.String
+		c.ProbeErrors = probeErrs.String
+		c.Denial = denial{
+			Type:       den.String,
+			Iterations: int(iters.Int64),
+			SaltLen:    int(salt.Int64),
+			OptOut:     optOut.Bool,
+		}
+		if edeCode.Valid {
+			c.EDE = &ede{Code: uint16(edeCode.Int64), Text: edeText.String}
+		}
+		c.CheckedAt = c.CheckedAtTime.Format("2006-01-02 15:04")
+		checks = append(checks, c)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+
+	for i := range checks {
+		checks[i].DS, err = loadDS(ctx, srv.db, "ds_records", checks[i].ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// only the latest check's nameservers and MX hosts are worth showing
+	var (
+		ns    []nsAnswer
+		names []string
+		mx    []mxAnswer
+	)
+	if len(checks) > 0 {
+		if ns, err = loadNSAnswers(ctx, srv.db, checks[0].ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if names, err = loadNSNames(ctx, srv.db, checks[0].ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if mx, err = loadMX(ctx, srv.db, checks[0].ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tld, err := loadTLD(ctx, srv.db, rec.TLD)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Domain      domainRow
+		TLD         *tldRow
+		Checks      []checkRow
+		Nameservers []nsAnswer
+		NSNames     []string
+		NSProblem   string
+		SerialSkew  bool
+		Provider    string
+		MX          []mxAnswer
+	}{
+		Domain:      rec,
+		TLD:         tld,
+		Checks:      checks,
+		Nameservers: ns,
+		NSNames:     names,
+		SerialSkew:  serialSkew(ns),
+		MX:          mx,
+	}
+	if len(checks) > 0 {
+		data.NSProblem = checks[0].NSProblem
+		data.Provider = checks[0].Provider
+	}
+	if err := templates.ExecuteTemplate(w, "domain", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// zones whose signatures run out sooner than this get flagged; set from
+// RRSIG_WARN_WINDOW
+var expiryWindow = 7 * 24 * time.Hour
+
+type expiryRow struct {
+	Name    string
+	Rank    int
+	Expires time.Time
+	Left    time.Duration
+	Soon    bool
+	Expired bool
+}
+
+// zoneExpiries is the per-domain version of sigExpiry, in SQL.
+const zoneExpiries = `
+	SELECT domain_id, MIN(expiration) AS expiration
+	FROM (
+		SELECT domain_id, type_covered, MAX(expiration) AS expiration
+		FROM signatures
+		GROUP BY domain_id, type_covered
+	)
+	GROUP BY domain_id`
+
+func expiringCount(ctx context.Context, db *sql.DB, within time.Duration) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM (`+zoneExpiries+`) WHERE expiration < ?`,
+		time.Now().Add(within).Unix(),
+	).Scan(&count)
+	return count, err
+}
+
+func (srv *DNSSECMeNot) handleExpiring(w http.ResponseWriter, r *http.Request) {
+	rows, err := srv.db.QueryContext(r.Context(), `
+		SELECT d.name, d.rank, e.expiration
+		FROM (`+zoneExpiries+`) e
+		JOIN domains d ON d.id = e.domain_id
+		ORDER BY e.expiration ASC, d.rank ASC
+		LIMIT 200`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	list := make([]expiryRow, 0, 64)
+	for rows.Next() {
+		var (
+			rec expiryRow
+			exp int64
+		)
+		if err := rows.Scan(&rec.Name, &rec.Rank, &exp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rec.Expires = time.Unix(exp, 0).UTC()
+		rec.Left = rec.Expires.Sub(now)
+		rec.Soon = rec.Left < expiryWindow
+		rec.Expired = rec.Left < 0
+		list = append(list, rec)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Zones  []expiryRow
+		Window time.Duration
+	}{
+		Zones:  list,
+		Window: expiryWindow,
+	}
+	if err := templates.ExecuteTemplate(w, "expiring", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
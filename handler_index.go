@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 // results per page
@@ -16,15 +18,46 @@ type domainRow struct {
 	Rank          int
 	Name          string
 	Base          string
-	TLD           string
+	TLD           string // the public suffix, co.uk for bbc.co.uk
+	Registrable   string
 	Important     bool
 	Class         string
-	HasDNSSEC     bool
+	Status        string // "" if never checked
+	HasDNSKEY     bool
+	DANE          string
+	DS            []dsRecord
+	checkID       int
 	CheckedAt     string
 	CheckedAtTime time.Time
 }
 
+// dnssecRatio is the share of top-N domains whose latest check found
+// them secure, out of those whose latest check found anything at all.
+// Domains that have never been checked, or whose last check failed, are
+// left out rather than guessed at.
 func dnssecRatio(ctx context.Context, db *sql.DB, limit int) (float64, error) {
+	var secure, known int
+	err := db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(c.status = ?), 0),
+                        COALESCE(SUM(c.status IN (?, ?, ?)), 0)
+                 FROM domains d
+                 JOIN dns_checks c ON c.id = (
+                     SELECT id FROM dns_checks dc
+                     WHERE dc.domain_id = d.id
+                     ORDER BY dc.checked_at DESC LIMIT 1
+                 )
+                 WHERE d.rank <= ?`,
+		statusSecure, statusSecure, statusInsecure, statusBogus, limit,
+	).Scan(&secure, &known)
+	if err != nil || known == 0 {
+		return 0, err
+	}
+	return 100 * float64(secure) / float64(known), nil
+}
+
+// unknownCount counts top-N domains the percentages leave out: never
+// checked, or the last check failed.
+func unknownCount(ctx context.Context, db *sql.DB, limit int) (int, error) {
 	var count int
 	err := db.QueryRowContext(ctx,
 		`SELECT COUNT(*)
@@ -34,29 +67,241 @@ func dnssecRatio(ctx context.Context, db *sql.DB, limit int) (float64, error) {
                      WHERE dc.domain_id = d.id
                      ORDER BY dc.checked_at DESC LIMIT 1
                  )
-                 WHERE d.rank <= ? AND c.has_dnssec = 1`,
-		limit,
+                 WHERE d.rank <= ?
+                 AND COALESCE(c.status, ?) = ?`,
+		limit, statusUnknown, statusUnknown,
 	).Scan(&count)
+	return count, err
+}
+
+// signedNoDSCount counts top-N domains that serve DNSKEYs but have no DS
+// at the parent.
+func signedNoDSCount(ctx context.Context, db *sql.DB, limit int) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*)
+                 FROM domains d
+                 JOIN dns_checks c ON c.id = (
+                     SELECT id FROM dns_checks dc
+                     WHERE dc.domain_id = d.id
+                     ORDER BY dc.checked_at DESC LIMIT 1
+                 )
+                 WHERE d.rank <= ? AND c.status = ? AND c.has_dnskey = 1`,
+		limit, statusInsecure,
+	).Scan(&count)
+	return count, err
+}
+
+// the algorithms people ask about; RSASHA1 counts its NSEC3 alias too
+var trackedAlgorithms = []struct {
+	Name string
+	Algs []uint8
+}{
+	{"RSASHA1", []uint8{dns.RSASHA1, dns.RSASHA1NSEC3SHA1}},
+	{"RSASHA256", []uint8{dns.RSASHA256}},
+	{"ECDSAP256SHA256", []uint8{dns.ECDSAP256SHA256}},
+	{"Ed25519", []uint8{dns.ED25519}},
+}
+
+type algoShare struct {
+	Name string
+	Pct  float64
+}
+
+type algoStats struct {
+	Signed     int
+	Algorithms []algoShare
+	SHA1Only   float64
+}
+
+// signedChecks selects the latest check for every signed domain in the
+// top-N, for the algorithm queries below. Bogus zones count: their keys
+// are still out there.
+const signedChecks = `
+	WITH signed AS (
+		SELECT c.id
+		FROM domains d
+		JOIN dns_checks c ON c.id = (
+			SELECT id FROM dns_checks dc
+			WHERE dc.domain_id = d.id
+			ORDER BY dc.checked_at DESC LIMIT 1
+		)
+		WHERE d.rank <= ? AND c.status IN ('secure', 'bogus')
+	)`
+
+// algorithmStats reports, among signed domains in the top-N, the share
+// whose zone keys use each tracked algorithm, and the share whose DS
+// records only offer SHA-1 digests.
+func algorithmStats(ctx context.Context, db *sql.DB, limit int) (algoStats, error) {
+	var st algoStats
+	err := db.QueryRowContext(ctx, signedChecks+`
+		SELECT COUNT(*),
+		       COALESCE(SUM(
+		           EXISTS (SELECT 1 FROM ds_records r WHERE r.check_id = s.id)
+		           AND NOT EXISTS (
+		               SELECT 1 FROM ds_records r
+		               WHERE r.check_id = s.id AND r.digest_type != ?
+		           )
+		       ), 0)
+		FROM signed s`,
+		limit, dns.SHA1,
+	).Scan(&st.Signed, &st.SHA1Only)
 	if err != nil {
-		return 0, err
+		return st, err
+	}
+
+	rows, err := db.QueryContext(ctx, signedChecks+`
+		SELECT DISTINCT k.check_id, k.algorithm
+		FROM dnskey_records k
+		JOIN signed s ON s.id = k.check_id`,
+		limit,
+	)
+	if err != nil {
+		return st, err
 	}
-	return 100 * float64(count) / float64(limit), nil
+	defer rows.Close()
+
+	// check ids per algorithm, so a zone with both RSASHA1 variants
+	// still only counts once
+	users := make(map[uint8][]int)
+	for rows.Next() {
+		var (
+			id  int
+			alg uint8
+		)
+		if err := rows.Scan(&id, &alg); err != nil {
+			return st, err
+		}
+		users[alg] = append(users[alg], id)
+	}
+	if err := rows.Err(); err != nil {
+		return st, err
+	}
+
+	if st.Signed == 0 {
+		return st, nil
+	}
+	st.SHA1Only = 100 * st.SHA1Only / float64(st.Signed)
+	for _, ta := range trackedAlgorithms {
+		seen := make(map[int]bool)
+		for _, alg := range ta.Algs {
+			for _, id := range users[alg] {
+				seen[id] = true
+			}
+		}
+		st.Algorithms = append(st.Algorithms, algoShare{
+			Name: ta.Name,
+			Pct:  100 * float64(len(seen)) / float64(st.Signed),
+		})
+	}
+	return st, nil
+}
+
+type denialStats struct {
+	Signed    int
+	NSEC      float64
+	NSEC3     float64
+	Compact   float64
+	Compliant float64 // share of NSEC3 zones following RFC 9276
+}
+
+// denialRatios breaks down how signed top-N zones deny existence.
+func denialRatios(ctx context.Context, db *sql.DB, limit int) (denialStats, error) {
+	var (
+		st                          denialStats
+		nsec, nsec3, compact, comps int
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+		       COALESCE(SUM(c.denial = ?), 0),
+		       COALESCE(SUM(c.denial = ?), 0),
+		       COALESCE(SUM(c.denial = ?), 0),
+		       COALESCE(SUM(c.denial = ?
+		           AND c.nsec3_iterations = 0
+		           AND c.nsec3_salt_len = 0), 0)
+		FROM domains d
+		JOIN dns_checks c ON c.id = (
+			SELECT id FROM dns_checks dc
+			WHERE dc.domain_id = d.id
+			ORDER BY dc.checked_at DESC LIMIT 1
+		)
+		WHERE d.rank <= ? AND COALESCE(c.denial, '') != ''`,
+		denialNSEC, denialNSEC3, denialCompact, denialNSEC3, limit,
+	).Scan(&st.Signed, &nsec, &nsec3, &compact, &comps)
+	if err != nil || st.Signed == 0 {
+		return st, err
+	}
+
+	st.NSEC = 100 * float64(nsec) / float64(st.Signed)
+	st.NSEC3 = 100 * float64(nsec3) / float64(st.Signed)
+	st.Compact = 100 * float64(compact) / float64(st.Signed)
+	if nsec3 > 0 {
+		st.Compliant = 100 * float64(comps) / float64(nsec3)
+	}
+	return st, nil
+}
+
+type providerShare struct {
+	Name    string
+	Domains int // with a known status
+	Pct     float64
+}
+
+// providerRatios is dnssecRatio broken down by who runs DNS for the
+// domain, biggest providers first. Domains we haven't attributed yet
+// are left out.
+func providerRatios(ctx context.Context, db *sql.DB, limit int) ([]providerShare, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.provider,
+		       COUNT(*),
+		       100.0 * SUM(c.status = ?) / COUNT(*)
+		FROM domains d
+		JOIN dns_checks c ON c.id = (
+			SELECT id FROM dns_checks dc
+			WHERE dc.domain_id = d.id
+			ORDER BY dc.checked_at DESC LIMIT 1
+		)
+		WHERE d.rank <= ?
+		AND COALESCE(c.provider, '') != ''
+		AND c.status IN (?, ?, ?)
+		GROUP BY c.provider
+		ORDER BY 2 DESC, 1`,
+		statusSecure, limit, statusSecure, statusInsecure, statusBogus,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []providerShare
+	for rows.Next() {
+		var p providerShare
+		if err := rows.Scan(&p.Name, &p.Domains, &p.Pct); err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, rows.Err()
 }
 
 func classRatios(ctx context.Context, db *sql.DB) (map[string]float64, error) {
 	rows, err := db.QueryContext(
 		ctx,
 		`SELECT class,
-                100.0 * SUM(COALESCE(has_dnssec,0)) / COUNT(1) AS pct
+                -- a DS that doesn't validate is breakage, not adoption,
+                -- and a failed check is no answer at all
+                100.0 * SUM(status = 'secure')
+                    / NULLIF(SUM(status IN ('secure', 'insecure', 'bogus')), 0)
+                    AS pct
         FROM (
             SELECT d.class,
             (
-                SELECT c.has_dnssec
+                SELECT c.status
                 FROM dns_checks c
                 WHERE c.domain_id = d.id
                 ORDER BY c.checked_at DESC
                 LIMIT 1
-            ) AS has_dnssec
+            ) AS status
             FROM domains d
             WHERE d.rank <= 1000
             AND d.class IS NOT NULL
@@ -73,12 +318,15 @@ func classRatios(ctx context.Context, db *sql.DB) (map[string]float64, error) {
 	for rows.Next() {
 		var (
 			class string
-			pct   float64
+			pct   sql.NullFloat64
 		)
 		if err := rows.Scan(&class, &pct); err != nil {
 			return nil, err
 		}
-		m[class] = pct
+		// nothing known about the class yet
+		if pct.Valid {
+			m[class] = pct.Float64
+		}
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -98,7 +346,8 @@ func (srv *DNSSECMeNot) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 	offset := (page - 1) * perPage
 	rows, err := srv.db.Query(`
-		SELECT d.rank, d.name, d.class, c.has_dnssec, c.checked_at
+		SELECT d.rank, d.name, d.public_suffix, d.class, c.id, c.status,
+               c.has_dnskey, c.dane, c.checked_at
         FROM domains d
         LEFT JOIN dns_checks c ON c.id = (
             SELECT id FROM dns_checks dc
@@ -120,20 +369,30 @@ func (srv *DNSSECMeNot) handleIndex(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var (
 			rec     domainRow
+			suffix  sql.NullString
 			class   sql.NullString
-			sec     sql.NullBool
+			checkID sql.NullInt64
+			status  sql.NullString
+			keys    sql.NullBool
+			dane    sql.NullString
 			checked sql.NullTime
 		)
-		if err := rows.Scan(&rec.Rank, &rec.Name, &class, &sec, &checked); err != nil {
+		if err := rows.Scan(
+			&rec.Rank, &rec.Name, &suffix, &class, &checkID, &status,
+			&keys, &dane, &checked,
+		); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		rec.Base, rec.TLD = domainParts(rec.Name)
+		rec.Base, rec.TLD = domainParts(rec.Name, suffix.String)
 		rec.Important = isImportantTLD(rec.TLD)
 		if class.Valid {
 			rec.Class = class.String
 		}
-		rec.HasDNSSEC = sec.Valid && sec.Bool
+		rec.Status = status.String
+		rec.HasDNSKEY = keys.Valid && keys.Bool
+		rec.DANE = dane.String
+		rec.checkID = int(checkID.Int64)
 		if checked.Valid {
 			rec.CheckedAtTime = checked.Time
 			rec.CheckedAt = checked.Time.Format("2006-01-02 15:04")
@@ -145,6 +404,18 @@ func (srv *DNSSECMeNot) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	rows.Close()
+
+	for i := range list {
+		if s := list[i].Status; s != statusSecure && s != statusBogus {
+			continue
+		}
+		list[i].DS, err = loadDS(r.Context(), srv.db, "ds_records", list[i].checkID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	hasNext := len(list) > perPage
 	if hasNext {
@@ -154,7 +425,20 @@ func (srv *DNSSECMeNot) handleIndex(w http.ResponseWriter, r *http.Request) {
 	p500, err2 := dnssecRatio(r.Context(), srv.db, 500)
 	p100, err3 := dnssecRatio(r.Context(), srv.db, 100)
 	classPcts, err4 := classRatios(r.Context(), srv.db)
-	if err = errors.Join(err1, err2, err3, err4); err != nil {
+	algos, err5 := algorithmStats(r.Context(), srv.db, 1000)
+	noDS, err6 := signedNoDSCount(r.Context(), srv.db, 1000)
+	cds, err7 := cdsCounts(r.Context(), srv.db, 1000)
+	expiring, err8 := expiringCount(r.Context(), srv.db, expiryWindow)
+	denials, err9 := denialRatios(r.Context(), srv.db, 1000)
+	unknown, err10 := unknownCount(r.Context(), srv.db, 1000)
+	providers, err11 := providerRatios(r.Context(), srv.db, 1000)
+	_, tlds, err12 := tldStats(r.Context(), srv.db, 1000)
+	mail, err13 := mailRatios(r.Context(), srv.db, 1000)
+	err = errors.Join(
+		err1, err2, err3, err4, err5, err6, err7, err8, err9, err10,
+		err11, err12, err13,
+	)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -167,6 +451,16 @@ func (srv *DNSSECMeNot) handleIndex(w http.ResponseWriter, r *http.Request) {
 		Pct500    float64
 		Pct100    float64
 		ClassPcts map[string]float64
+		Algos     algoStats
+		NoDS      int
+		CDS       cdsStats
+		Expiring  int
+		Window    time.Duration
+		Denial    denialStats
+		Unknown   int
+		Providers []providerShare
+		TLDs      tldSummary
+		Mail      mailStats
 	}{
 		Domains:   list,
 		Page:      page,
@@ -174,6 +468,16 @@ func (srv *DNSSECMeNot) handleIndex(w http.ResponseWriter, r *http.Request) {
 		Pct500:    p500,
 		Pct100:    p100,
 		ClassPcts: classPcts,
+		Algos:     algos,
+		NoDS:      noDS,
+		CDS:       cds,
+		Expiring:  expiring,
+		Window:    expiryWindow,
+		Denial:    denials,
+		Unknown:   unknown,
+		Providers: providers,
+		TLDs:      tlds,
+		Mail:      mail,
 	}
 	if page > 1 {
 		data.PrevPage = page - 1
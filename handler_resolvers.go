@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type resolverRow struct {
+	Address       string
+	Queries       int64
+	SuccessPct    float64
+	TimeoutPct    float64
+	DisagreePct   float64
+	AvgRTT        time.Duration
+	Streak        int
+	DisabledUntil time.Time
+	LastError     string
+}
+
+func (r resolverRow) InRotation() bool {
+	return time.Now().After(r.DisabledUntil)
+}
+
+// resolverHealth reports the saved counters for every resolver currently
+// in the pool, in pool order.
+func resolverHealth(ctx context.Context, db *sql.DB) ([]resolverRow, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT address, queries, failures, timeouts, disagreements,
+		       ds_lookups, rtt_ms, streak, disabled_until, last_error
+		FROM resolvers`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	saved := make(map[string]resolverRow)
+	for rows.Next() {
+		var (
+			r                              resolverRow
+			fails, touts, dis, ds, ms, uts int64
+		)
+		if err := rows.Scan(&r.Address, &r.Queries, &fails, &touts, &dis,
+			&ds, &ms, &r.Streak, &uts, &r.LastError); err != nil {
+			return nil, err
+		}
+		if r.Queries > 0 {
+			q := float64(r.Queries)
+			r.SuccessPct = 100 * float64(r.Queries-fails) / q
+			r.TimeoutPct = 100 * float64(touts) / q
+		}
+		// out of the DS lookups, not every query: only those can disagree
+		if ds > 0 {
+			r.DisagreePct = 100 * float64(dis) / float64(ds)
+		}
+		if ok := r.Queries - fails; ok > 0 {
+			r.AvgRTT = time.Duration(ms) * time.Millisecond / time.Duration(ok)
+		}
+		if uts > 0 {
+			r.DisabledUntil = time.Unix(uts, 0)
+		}
+		saved[r.Address] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var ret []resolverRow
+	for _, addr := range pool.addresses() {
+		r, ok := saved[addr]
+		if !ok {
+			r.Address = addr
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+type failureCount struct {
+	Code  string
+	Count int
+}
+
+// failureCounts tallies how the latest checks of the top-N domains
+// failed, by kind.
+func failureCounts(ctx context.Context, db *sql.DB, limit int) ([]failureCount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(NULLIF(c.error_code, ''), ?), COUNT(*)
+		FROM domains d
+		JOIN dns_checks c ON c.id = (
+			SELECT id FROM dns_checks dc
+			WHERE dc.domain_id = d.id
+			ORDER BY dc.checked_at DESC LIMIT 1
+		)
+		WHERE d.rank <= ? AND c.error IS NOT NULL AND c.error != ''
+		GROUP BY 1
+		ORDER BY 2 DESC, 1`,
+		failOther, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []failureCount
+	for rows.Next() {
+		var f failureCount
+		if err := rows.Scan(&f.Code, &f.Count); err != nil {
+			return nil, err
+		}
+		ret = append(ret, f)
+	}
+	return ret, rows.Err()
+}
+
+func (srv *DNSSECMeNot) handleResolvers(w http.ResponseWriter, r *http.Request) {
+	list, err1 := resolverHealth(r.Context(), srv.db)
+	failures, err2 := failureCounts(r.Context(), srv.db, 1000)
+	if err := errors.Join(err1, err2); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Resolvers  []resolverRow
+		Failures   []failureCount
+		EvictAfter int
+		EvictFor   time.Duration
+	}{
+		Resolvers:  list,
+		Failures:   failures,
+		EvictAfter: evictAfter,
+		EvictFor:   evictFor,
+	}
+	if err := templates.ExecuteTemplate(w, "resolvers", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "net/http"
+
+func (srv *DNSSECMeNot) handleTLDs(w http.ResponseWriter, r *http.Request) {
+	list, sum, err := tldStats(r.Context(), srv.db, 1000)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		TLDs    []tldRow
+		Summary tldSummary
+	}{
+		TLDs:    list,
+		Summary: sum,
+	}
+	if err := templates.ExecuteTemplate(w, "tlds", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
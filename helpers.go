@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/miekg/dns"
 )
 
 func relativeTime(t time.Time) string {
@@ -29,7 +32,38 @@ func relativeTime(t time.Time) string {
 	}
 }
 
-func domainParts(name string) (string, string) {
+// parseTimestamp reads a timestamp that came out of an SQLite expression
+// rather than a TIMESTAMP column, so the driver left it as text.
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, f := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(f, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad timestamp %q", s)
+}
+
+// shortDuration renders spans of hours to weeks, like "3 d 4 h".
+func shortDuration(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%d m", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d h", int(d.Hours()))
+	default:
+		days := int(d.Hours() / 24)
+		return fmt.Sprintf("%d d %d h", days, int(d.Hours())-24*days)
+	}
+}
+
+// domainParts splits name into what's left of its public suffix and the
+// suffix, for display. suffix is "" for domains nobody has split yet,
+// which fall back to their last label.
+func domainParts(name, suffix string) (string, string) {
+	if suffix != "" && suffix != name {
+		return strings.TrimSuffix(name, "."+suffix), suffix
+	}
 	i := strings.LastIndexByte(name, '.')
 	if i < 0 {
 		return name, ""
@@ -37,8 +71,9 @@ func domainParts(name string) (string, string) {
 	return name[:i], name[i+1:]
 }
 
-func isImportantTLD(tld string) bool {
-	switch tld {
+// isImportantTLD goes by the last label, so gov.uk counts along with gov.
+func isImportantTLD(suffix string) bool {
+	switch suffix[strings.LastIndexByte(suffix, '.')+1:] {
 	case "mil", "gov", "eu":
 		return true
 	}
@@ -64,3 +99,17 @@ func classColor(class string) string {
 	}
 	return c
 }
+
+func algName(alg uint8) string {
+	if s, ok := dns.AlgorithmToString[alg]; ok {
+		return s
+	}
+	return fmt.Sprintf("ALG%d", alg)
+}
+
+func digestName(typ uint8) string {
+	if s, ok := dns.HashToString[typ]; ok {
+		return s
+	}
+	return fmt.Sprintf("DIGEST%d", typ)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// set from DNS_MODE=iterative; see main
+var iterativeMode bool
+
+// rootHints are the IPv4 addresses of a.root-servers.net through
+// m.root-servers.net.
+var rootHints = []string{
+	"198.41.0.4",
+	"170.247.170.2",
+	"192.33.4.12",
+	"199.7.91.13",
+	"192.203.230.10",
+	"192.5.5.241",
+	"192.112.36.4",
+	"198.97.190.53",
+	"192.36.148.17",
+	"192.58.128.30",
+	"193.0.14.129",
+	"199.7.83.42",
+	"202.12.27.33",
+}
+
+// authPort is where we expect authoritative servers to listen. Tests run
+// a fake tree on loopback addresses and point this at their port.
+var authPort = "53"
+
+const (
+	// how many referrals we'll follow before deciding something's looping
+	maxReferrals = 16
+
+	// how deep we'll go chasing addresses for glueless NS names
+	maxGlueDepth = 3
+)
+
+// iterativeDS walks the delegation from the root hints down to domain's
+// parent and asks the parent's own servers for the DS set, so no
+// resolver cache or policy sits between us and the answer.
+func iterativeDS(ctx context.Context, domain string) ([]dns.RR, error) {
+	r, err := iterate(ctx, domain, dns.TypeDS, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode == dns.RcodeNameError {
+		return nil, nil
+	}
+	set, _ := rrsetOf(r.Answer, domain, dns.TypeDS)
+	return set, nil
+}
+
+// iterate follows referrals for name/qtype starting at the root until a
+// server answers authoritatively.
+func iterate(ctx context.Context, name string, qtype uint16, depth int) (*dns.Msg, error) {
+	if depth > maxGlueDepth {
+		return nil, fmt.Errorf("%s: too many glueless hops", name)
+	}
+
+	var (
+		zone    = "."
+		servers = rootHints
+		qname   = dns.Fqdn(name)
+	)
+	for i := 0; i < maxReferrals; i++ {
+		r, err := askAny(ctx, servers, qname, qtype)
+		if err != nil {
+			return nil, fmt.Errorf("%s servers: %w", zone, err)
+		}
+
+		switch r.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError:
+		default:
+			return nil, fmt.Errorf("%s servers: %s %s: %s", zone, qname,
+				dns.TypeToString[qtype], dns.RcodeToString[r.Rcode])
+		}
+		if r.Authoritative || len(r.Answer) > 0 || r.Rcode == dns.RcodeNameError {
+			return r, nil
+		}
+
+		child, hosts := referral(r)
+		if child == "" {
+			return nil, fmt.Errorf("%s servers: no answer and no referral for %s",
+				zone, qname)
+		}
+		if !dns.IsSubDomain(zone, child) || dns.CanonicalName(child) == dns.CanonicalName(zone) {
+			return nil, fmt.Errorf("%s servers: bogus referral to %s", zone, child)
+		}
+
+		addrs := glue(r, hosts)
+		if len(addrs) == 0 {
+			for _, h := range hosts {
+				a, err := iterate(ctx, h, dns.TypeA, depth+1)
+				if err != nil {
+					continue
+				}
+				addrs = append(addrs, addrsOf(a.Answer, h)...)
+				if len(addrs) > 0 {
+					break
+				}
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("%s: no usable addresses for %v", child, hosts)
+		}
+
+		zone, servers = child, addrs
+	}
+
+	return nil, fmt.Errorf("%s: too many referrals", qname)
+}
+
+// askAny tries servers in random order until one of them answers.
+func askAny(ctx context.Context, servers []string, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = false
+	m.SetEdns0(4096, true)
+
+	var errs []error
+	for _, s := range kOfN(len(servers), servers) {
+		r, err := exchange(ctx, net.JoinHostPort(s, authPort), m)
+		if err == nil {
+			return r, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// referral returns the zone a response delegates to and its NS names.
+func referral(r *dns.Msg) (child string, hosts []string) {
+	for _, rr := range r.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		if child == "" {
+			child = ns.Hdr.Name
+		}
+		if dns.CanonicalName(ns.Hdr.Name) == dns.CanonicalName(child) {
+			hosts = append(hosts, ns.Ns)
+		}
+	}
+	return
+}
+
+// glue finds IPv4 addresses for hosts in the additional section.
+func glue(r *dns.Msg, hosts []string) (ret []string) {
+	for _, h := range hosts {
+		ret = append(ret, addrsOf(r.Extra, h)...)
+	}
+	return
+}
+
+func addrsOf(rrs []dns.RR, host string) (ret []string) {
+	host = dns.CanonicalName(host)
+	for _, rr := range rrs {
+		if a, ok := rr.(*dns.A); ok && dns.CanonicalName(a.Hdr.Name) == host {
+			ret = append(ret, a.A.String())
+		}
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// authServer is a toy authoritative server: it answers for its own data
+// and hands out referrals (with whatever glue it's given) for its cuts.
+type authServer struct {
+	rrs  map[string][]dns.RR
+	cuts map[string][]string // child zone -> NS names
+	glue map[string]string   // NS name -> address
+}
+
+func newAuthServer() *authServer {
+	return &authServer{
+		rrs:  map[string][]dns.RR{},
+		cuts: map[string][]string{},
+		glue: map[string]string{},
+	}
+}
+
+func (a *authServer) add(t *testing.T, lines ...string) {
+	t.Helper()
+	for _, l := range lines {
+		rr := mustRR(t, l)
+		k := zoneKey(rr.Header().Name, rr.Header().Rrtype)
+		a.rrs[k] = append(a.rrs[k], rr)
+	}
+}
+
+func (a *authServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	q := req.Question[0]
+
+	for child, hosts := range a.cuts {
+		if !dns.IsSubDomain(child, q.Name) {
+			continue
+		}
+		// DS lives on the parent side of the cut
+		if q.Qtype == dns.TypeDS && dns.CanonicalName(q.Name) == child {
+			continue
+		}
+		for _, h := range hosts {
+			m.Ns = append(m.Ns, &dns.NS{
+				Hdr: dns.RR_Header{Name: child, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 300},
+				Ns:  h,
+			})
+			if ip, ok := a.glue[h]; ok {
+				m.Extra = append(m.Extra, &dns.A{
+					Hdr: dns.RR_Header{Name: h, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+					A:   net.ParseIP(ip),
+				})
+			}
+		}
+		w.WriteMsg(m)
+		return
+	}
+
+	m.Authoritative = true
+	m.Answer = a.rrs[zoneKey(q.Name, q.Qtype)]
+	w.WriteMsg(m)
+}
+
+// TestIterativeDS builds root -> test. -> {good,hosted}.test on loopback
+// addresses. hosted.test's servers have no glue, so finding them means
+// resolving ns.hosting.test on the way.
+func TestIterativeDS(t *testing.T) {
+	var (
+		root    = newAuthServer()
+		tld     = newAuthServer()
+		hosting = newAuthServer()
+	)
+
+	root.cuts["test."] = []string{"ns.test."}
+	root.glue["ns.test."] = "127.0.0.2"
+
+	tld.cuts["hosting.test."] = []string{"ns.hosting.test."}
+	tld.glue["ns.hosting.test."] = "127.0.0.3"
+	tld.cuts["hosted.test."] = []string{"ns.hosting.test."}
+	tld.cuts["good.test."] = []string{"ns.hosting.test."}
+	tld.add(t, "good.test. 300 IN DS 12345 13 2 "+
+		"D60B11ED0F72B6D08C651EC31155013FFCCA1B19FC3D45FF19DB98643035BB67")
+
+	hosting.add(t, "ns.hosting.test. 300 IN A 127.0.0.3")
+	hosting.cuts["sub.hosted.test."] = []string{"ns.hosting.test."}
+	hosting.add(t, "sub.hosted.test. 300 IN DS 54321 8 2 "+
+		"683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16")
+
+	addr := serveDNS(t, root)
+	_, port, _ := net.SplitHostPort(addr)
+	serveDNSOn(t, "127.0.0.2:"+port, tld)
+	serveDNSOn(t, "127.0.0.3:"+port, hosting)
+
+	oldHints, oldPort := rootHints, authPort
+	rootHints, authPort = []string{"127.0.0.1"}, port
+	t.Cleanup(func() { rootHints, authPort = oldHints, oldPort })
+
+	ctx := context.Background()
+	for name, tag := range map[string]uint16{
+		"good.test":       12345,
+		"plain.test":      0,
+		"sub.hosted.test": 54321,
+	} {
+		rrs, err := iterativeDS(ctx, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ds := dsRecords(rrs)
+		switch {
+		case tag == 0 && len(ds) != 0:
+			t.Errorf("%s: want no DS got %v", name, ds)
+		case tag != 0 && (len(ds) != 1 || ds[0].KeyTag != tag):
+			t.Errorf("%s: want DS %d got %v", name, tag, ds)
+		}
+	}
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"embed"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -14,6 +14,7 @@ import (
 	"math/rand/v2"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"time"
 
@@ -30,6 +31,9 @@ var templates = template.Must(
 	template.New("").Funcs(template.FuncMap{
 		"relativeTime": relativeTime,
 		"classColor":   classColor,
+		"algName":      algName,
+		"digestName":   digestName,
+		"duration":     shortDuration,
 	}).ParseFS(templatesFS, "templates/*.html"),
 )
 
@@ -45,9 +49,85 @@ func main() {
 		updatePath = flag.String("update-classes", "", "load classes")
 		listFlag   = flag.Bool("list-unclassed", false, "list domains")
 		setClass   = flag.String("set-class", "", "domain,cls")
+		updateProv = flag.Bool("update-providers", false, "re-attribute recorded NS sets to providers")
+		updateSuf  = flag.Bool("update-suffixes", false, "re-split every domain using the Public Suffix List")
+		sweepRank  = flag.Int("sweep", 0, "check every domain up to this rank, then exit")
+		bulkPath   = flag.String("bulk", "", "look up DS for domains in this file (- for stdin) and exit; no database")
+		bulkFormat = flag.String("format", "table", "-bulk output: table, csv or ndjson")
+		checkName  = flag.String("check", "", "run every probe against one domain, print a report and exit")
+		record     = flag.Bool("record", false, "with -check, also record the result in dns_checks")
 	)
 	flag.Parse()
 
+	switch mode := getEnv("DNS_MODE", "resolver"); mode {
+	case "resolver":
+	case "iterative":
+		iterativeMode = true
+	default:
+		slog.Error("unknown DNS_MODE", "mode", mode)
+		os.Exit(1)
+	}
+
+	if v := getEnv("RRSIG_WARN_WINDOW", ""); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			slog.Error("RRSIG_WARN_WINDOW", "err", err)
+			os.Exit(1)
+		}
+		expiryWindow = d
+	}
+
+	l, err := limiterFromEnv()
+	if err != nil {
+		slog.Error("rate limit", "err", err)
+		os.Exit(1)
+	}
+	limiter = l
+
+	if v := getEnv("PROVIDERS_FILE", ""); v != "" {
+		rules, err := loadProviders(v)
+		if err != nil {
+			slog.Error("PROVIDERS_FILE", "err", err)
+			os.Exit(1)
+		}
+		providerRules = rules
+	}
+
+	if v := getEnv("PSL_FILE", ""); v != "" {
+		l, err := loadSuffixes(v)
+		if err != nil {
+			slog.Error("PSL_FILE", "err", err)
+			os.Exit(1)
+		}
+		suffixes = l
+	}
+
+	if v := getEnv("RESOLVERS", ""); v != "" {
+		rs, err := parseResolvers(v)
+		if err != nil {
+			slog.Error("RESOLVERS", "err", err)
+			os.Exit(1)
+		}
+		pool = newResolverPool(rs)
+	}
+
+	if *bulkPath != "" {
+		os.Exit(bulkMain(*bulkPath, *bulkFormat))
+	}
+
+	if *checkName != "" {
+		// the report says it all
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr,
+			&slog.HandlerOptions{Level: slog.LevelWarn})))
+	}
+	if *checkName != "" && !*record {
+		if err := diagnose(context.Background(), nil, *checkName, os.Stdout); err != nil {
+			slog.Error("check", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	db, err := openDB( /* really should take the path arg here */ )
 	if err != nil {
 		slog.Error("open db", "err", err)
@@ -59,6 +139,10 @@ func main() {
 		slog.Error("seed", "err", err)
 		os.Exit(1)
 	}
+	if err := updateSuffixes(context.Background(), db, false); err != nil {
+		slog.Error("public suffixes", "err", err)
+		os.Exit(1)
+	}
 
 	switch {
 	case *updatePath != "":
@@ -81,6 +165,48 @@ func main() {
 			os.Exit(1)
 		}
 		return
+
+	case *updateProv:
+		if err := updateProviders(context.Background(), db); err != nil {
+			slog.Error("providers", "err", err)
+			os.Exit(1)
+		}
+		return
+
+	case *updateSuf:
+		if err := updateSuffixes(context.Background(), db, true); err != nil {
+			slog.Error("public suffixes", "err", err)
+			os.Exit(1)
+		}
+		return
+
+	case *checkName != "":
+		if err := diagnose(context.Background(), db, *checkName, os.Stdout); err != nil {
+			slog.Error("check", "err", err)
+			os.Exit(1)
+		}
+		return
+
+	case *sweepRank > 0:
+		workers, err := workersFromEnv()
+		if err != nil {
+			slog.Error("sweep", "err", err)
+			os.Exit(1)
+		}
+		// the progress line is enough; per-domain logging would trample it
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr,
+			&slog.HandlerOptions{Level: slog.LevelWarn})))
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := pool.load(ctx, db); err != nil {
+			slog.Error("load resolver stats", "err", err)
+			os.Exit(1)
+		}
+		if err := runSweep(ctx, db, *sweepRank, workers, os.Stdout); err != nil {
+			slog.Error("sweep", "err", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	// nope we're servering
@@ -92,6 +218,11 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if err := pool.load(ctx, db); err != nil {
+		slog.Error("load resolver stats", "err", err)
+		os.Exit(1)
+	}
+
 	if err := startScheduler(ctx, db); err != nil {
 		slog.Error("scheduler", "err", err)
 		os.Exit(1)
@@ -102,6 +233,11 @@ func main() {
 	mux := http.NewServeMux()
 	mux.Handle("/", http.HandlerFunc(srv.handleIndex))
 	mux.Handle("/changes", http.HandlerFunc(srv.handleChanges))
+	mux.Handle("/domain", http.HandlerFunc(srv.handleDomain))
+	mux.Handle("/cds", http.HandlerFunc(srv.handleCDS))
+	mux.Handle("/expiring", http.HandlerFunc(srv.handleExpiring))
+	mux.Handle("/resolvers", http.HandlerFunc(srv.handleResolvers))
+	mux.Handle("/tlds", http.HandlerFunc(srv.handleTLDs))
 	mux.Handle("/static/", http.FileServer(http.FS(staticFS)))
 
 	slog.Info("listening", "addr", address)
@@ -118,15 +254,6 @@ func getEnv(key, def string) string {
 	return def
 }
 
-var resolvers = []string{
-	"8.8.8.8:53",
-	"1.1.1.1:53",
-	"9.9.9.9:53",
-}
-
-// TODO: put this somewhere more sensible.
-var rng = rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0))
-
 func kOfN(k int, set []string) (ret []string) {
 	if k <= 0 {
 		return nil
@@ -136,7 +263,8 @@ func kOfN(k int, set []string) (ret []string) {
 		k = len(set)
 	}
 
-	idxs := rng.Perm(len(set))
+	// the top-level functions are safe to call from several workers
+	idxs := rand.Perm(len(set))
 
 	for i := 0; i < k; i++ {
 		ret = append(ret, set[idxs[i]])
@@ -145,29 +273,80 @@ func kOfN(k int, set []string) (ret []string) {
 	return
 }
 
-func lookupDS(ctx context.Context, domain string) ([]dns.RR, error) {
+// lookupDS asks two resolvers for a domain's DS set, and returns what
+// each of them said along with the verdict so disagreements can be looked
+// into later. Iterative mode has no resolvers to report on. Failures that
+// might be transient are retried with backoff.
+func lookupDS(ctx context.Context, domain string) (rrs []dns.RR, answers []resolverAnswer, err error) {
+	err = withRetry(ctx, "ds "+domain, func() (err error) {
+		if iterativeMode {
+			rrs, err = iterativeDS(ctx, domain)
+			return err
+		}
+		rrs, answers, err = lookupDSOnce(ctx, domain)
+		return err
+	})
+	return rrs, answers, err
+}
+
+func lookupDSOnce(ctx context.Context, domain string) ([]dns.RR, []resolverAnswer, error) {
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeDS)
+	// without EDNS a resolver has nowhere to put an EDE, and without DO
+	// it won't set AD
+	m.SetEdns0(4096, true)
 
-	c := new(dns.Client)
-	rs := kOfN(2, resolvers)
+	rs := pool.pick(2)
 
-	a, _, err1 := c.ExchangeContext(ctx, m, rs[0])
-	b, _, err2 := c.ExchangeContext(ctx, m, rs[1])
+	a, rtt1, err1 := ask(ctx, rs[0], m)
+	b, rtt2, err2 := ask(ctx, rs[1], m)
+	answers := []resolverAnswer{
+		answerOf(rs[0], a, rtt1, err1),
+		answerOf(rs[1], b, rtt2, err2),
+	}
 
-	if err := errors.Join(err1, err2); err != nil {
-		return nil, fmt.Errorf("two lookups: %w", err)
+	// which resolvers said what is in answers. The error leaves them out,
+	// since the pair changes from one check to the next and the same
+	// failure shouldn't look like a new one.
+	if err := cmp.Or(err1, err2); err != nil {
+		if code := classify(err); code != failBogus {
+			return nil, answers, failf(code, "ds lookup failed (%s)", code)
+		}
+		return nil, answers, err
+	}
+	for _, r := range []*dns.Msg{a, b} {
+		if r.Rcode != dns.RcodeSuccess {
+			return nil, answers, rcodeError("ds", r.Rcode)
+		}
 	}
 
+	pool.compared(rs...)
 	pa := len(a.Answer) > 0
 	pb := len(b.Answer) > 0
 	if pa != pb {
-		return nil, fmt.Errorf("mismatch")
+		pool.disagree(rs...)
+		return nil, answers, failf(failMismatch, "resolvers disagree about DS")
 	}
 	if !pa {
-		return nil, nil
+		return nil, answers, nil
+	}
+	return a.Answer, answers, nil
+}
+
+// lookupApex fetches an RRset the zone publishes about itself (DNSKEY,
+// CDS, CDNSKEY, SOA) along with its signatures. Unlike DS, these come from
+// the child zone, so they're there whether or not the parent knows about
+// them.
+func lookupApex(ctx context.Context, domain string, qtype uint16) ([]dns.RR, []*dns.RRSIG, error) {
+	r, err := query(ctx, pool.one(), domain, qtype)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, nil, rcodeError(strings.ToLower(dns.TypeToString[qtype]), r.Rcode)
 	}
-	return a.Answer, nil
+	set, sigs := rrsetOf(r.Answer, domain, qtype)
+	return set, sigs, nil
 }
 
 func loadClasses(db *sql.DB, path string) error {
@@ -0,0 +1,2 @@
+ALTER TABLE dns_checks ADD COLUMN validation TEXT;
+ALTER TABLE dns_checks ADD COLUMN validation_reason TEXT;
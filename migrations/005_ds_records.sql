@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS ds_records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    key_tag INTEGER NOT NULL,
+    algorithm INTEGER NOT NULL,
+    digest_type INTEGER NOT NULL,
+    digest TEXT NOT NULL,
+    ttl INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_ds_records_check_id ON ds_records(check_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS dnskey_records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    key_tag INTEGER NOT NULL,
+    flags INTEGER NOT NULL,
+    protocol INTEGER NOT NULL,
+    algorithm INTEGER NOT NULL,
+    public_key TEXT NOT NULL,
+    ttl INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_dnskey_records_check_id ON dnskey_records(check_id);
@@ -0,0 +1 @@
+ALTER TABLE dns_checks ADD COLUMN has_dnskey BOOLEAN;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS cds_records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    key_tag INTEGER NOT NULL,
+    algorithm INTEGER NOT NULL,
+    digest_type INTEGER NOT NULL,
+    digest TEXT NOT NULL,
+    ttl INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_cds_records_check_id ON cds_records(check_id);
+
+CREATE TABLE IF NOT EXISTS cdnskey_records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    key_tag INTEGER NOT NULL,
+    flags INTEGER NOT NULL,
+    protocol INTEGER NOT NULL,
+    algorithm INTEGER NOT NULL,
+    public_key TEXT NOT NULL,
+    ttl INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_cdnskey_records_check_id ON cdnskey_records(check_id);
+
+ALTER TABLE dns_checks ADD COLUMN cds_status TEXT;
+
+-- checked_at moves forward every time a check confirms the previous
+-- result; created_at is when that result was first seen
+ALTER TABLE dns_checks ADD COLUMN created_at TIMESTAMP;
+UPDATE dns_checks SET created_at = checked_at;
@@ -0,0 +1,13 @@
+-- the apex signatures as of the latest check; unix seconds, like RRSIGs
+CREATE TABLE IF NOT EXISTS signatures (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    type_covered INTEGER NOT NULL,
+    key_tag INTEGER NOT NULL,
+    algorithm INTEGER NOT NULL,
+    inception INTEGER NOT NULL,
+    expiration INTEGER NOT NULL,
+    checked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_signatures_domain_id ON signatures(domain_id);
@@ -0,0 +1,4 @@
+ALTER TABLE dns_checks ADD COLUMN denial TEXT;
+ALTER TABLE dns_checks ADD COLUMN nsec3_iterations INTEGER;
+ALTER TABLE dns_checks ADD COLUMN nsec3_salt_len INTEGER;
+ALTER TABLE dns_checks ADD COLUMN nsec3_opt_out BOOLEAN;
@@ -0,0 +1,14 @@
+-- running health counters for each configured resolver, so the pool
+-- survives restarts and the status page has something to show
+CREATE TABLE IF NOT EXISTS resolvers (
+    address TEXT PRIMARY KEY,
+    queries INTEGER NOT NULL DEFAULT 0,
+    failures INTEGER NOT NULL DEFAULT 0,
+    timeouts INTEGER NOT NULL DEFAULT 0,
+    disagreements INTEGER NOT NULL DEFAULT 0,
+    rtt_ms INTEGER NOT NULL DEFAULT 0, -- summed over answered queries
+    streak INTEGER NOT NULL DEFAULT 0, -- consecutive failures
+    disabled_until INTEGER NOT NULL DEFAULT 0, -- unix seconds
+    last_error TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
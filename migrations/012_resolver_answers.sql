@@ -0,0 +1,15 @@
+-- what each resolver said about DS for a check, so a mismatch can be
+-- picked apart later. An unchanged check keeps only the latest answers.
+CREATE TABLE IF NOT EXISTS resolver_answers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    resolver TEXT NOT NULL,
+    rcode INTEGER,
+    ad BOOLEAN NOT NULL DEFAULT 0,
+    tc BOOLEAN NOT NULL DEFAULT 0,
+    answer TEXT NOT NULL DEFAULT '', -- one RR per line, presentation format
+    rtt_ms INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_resolver_answers_check_id ON resolver_answers(check_id);
@@ -0,0 +1,17 @@
+-- the kind of failure (see failure.go), so errors can be counted by type
+ALTER TABLE dns_checks ADD COLUMN error_code TEXT;
+
+-- best guess for rows from before we classified anything
+UPDATE dns_checks SET error_code = CASE
+    WHEN error LIKE 'mismatch%' THEN 'mismatch'
+    WHEN error LIKE '%timeout%' THEN 'timeout'
+    WHEN error LIKE '%SERVFAIL%' THEN 'servfail'
+    WHEN error LIKE '%REFUSED%' THEN 'refused'
+    WHEN error LIKE '%NXDOMAIN%' THEN 'nxdomain'
+    WHEN error LIKE '%(tcp)%' THEN 'truncated'
+    WHEN error LIKE '%connection%' OR error LIKE '%network%' THEN 'network'
+    ELSE 'other'
+END
+WHERE error IS NOT NULL AND error != '';
+
+CREATE INDEX IF NOT EXISTS idx_dns_checks_error_code ON dns_checks(error_code);
@@ -0,0 +1,16 @@
+-- one-shot sweeps over the list (-sweep), so an interrupted one can pick
+-- up where it stopped and report what changed when it's done
+CREATE TABLE IF NOT EXISTS sweeps (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    max_rank INTEGER NOT NULL,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sweep_domains (
+    sweep_id INTEGER NOT NULL REFERENCES sweeps(id) ON DELETE CASCADE,
+    domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    before TEXT NOT NULL, -- statusLabel as of the start of the sweep
+    after TEXT,           -- NULL until checked
+    PRIMARY KEY (sweep_id, domain_id)
+);
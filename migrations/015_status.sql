@@ -0,0 +1,16 @@
+-- what a check actually established: secure, insecure, bogus, or unknown
+-- when it failed. has_dnssec stays as the raw "parent has DS" bit.
+ALTER TABLE dns_checks ADD COLUMN status TEXT;
+
+-- rows with errors used to carry the previous has_dnssec forward, so
+-- anything that failed is unknown regardless of what it says
+UPDATE dns_checks SET status = CASE
+    WHEN COALESCE(error, '') != '' THEN 'unknown'
+    WHEN has_dnssec IS NULL THEN 'unknown'
+    WHEN NOT has_dnssec THEN 'insecure'
+    WHEN validation = 'bogus' THEN 'bogus'
+    WHEN validation = 'indeterminate' THEN 'unknown'
+    ELSE 'secure'
+END;
+
+CREATE INDEX IF NOT EXISTS idx_dns_checks_status ON dns_checks(status);
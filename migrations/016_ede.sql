@@ -0,0 +1,4 @@
+-- the Extended DNS Error (RFC 8914) a validating resolver gave with its
+-- SERVFAIL, when a re-query with CD set showed the domain was bogus
+ALTER TABLE dns_checks ADD COLUMN ede_code INTEGER;
+ALTER TABLE dns_checks ADD COLUMN ede_text TEXT;
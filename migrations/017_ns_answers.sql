@@ -0,0 +1,18 @@
+-- what each of a signed zone's own nameservers said about its DNSKEY and
+-- SOA when asked directly. Like resolver_answers, an unchanged check
+-- keeps only the latest.
+CREATE TABLE IF NOT EXISTS ns_answers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    nameserver TEXT NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    key_tags TEXT NOT NULL DEFAULT '', -- space separated, ascending
+    signed BOOLEAN NOT NULL DEFAULT 0,
+    serial INTEGER,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_ns_answers_check_id ON ns_answers(check_id);
+
+-- how the nameservers disagreed, if they did
+ALTER TABLE dns_checks ADD COLUMN ns_problem TEXT;
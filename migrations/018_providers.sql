@@ -0,0 +1,13 @@
+-- the NS set each check saw, and who we think runs DNS for the domain
+-- going by it (see providers.json)
+CREATE TABLE IF NOT EXISTS ns_records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    name TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ns_records_check_id ON ns_records(check_id);
+
+ALTER TABLE dns_checks ADD COLUMN provider TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_dns_checks_provider ON dns_checks(provider);
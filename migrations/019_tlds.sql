@@ -0,0 +1,9 @@
+-- whether each TLD is itself signed; a domain under an unsigned TLD has
+-- nowhere to publish a DS, however much it might want to
+CREATE TABLE IF NOT EXISTS tlds (
+    name TEXT PRIMARY KEY,
+    has_ds BOOLEAN,
+    has_dnskey BOOLEAN,
+    error TEXT NOT NULL DEFAULT '',
+    checked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
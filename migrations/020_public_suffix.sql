@@ -0,0 +1,7 @@
+-- where each name splits into the suffix a registry hands names out under
+-- and the name registered there, going by the Public Suffix List; filled
+-- in at startup, and redone by -update-suffixes
+ALTER TABLE domains ADD COLUMN public_suffix TEXT;
+ALTER TABLE domains ADD COLUMN registrable TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_domains_public_suffix ON domains(public_suffix);
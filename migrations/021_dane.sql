@@ -0,0 +1,18 @@
+-- each check's mail exchangers, whether their zones are signed, and the
+-- TLSA records they publish for SMTP. Like ns_answers, an unchanged check
+-- keeps only the latest.
+CREATE TABLE IF NOT EXISTS mx_records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    host TEXT NOT NULL,
+    preference INTEGER NOT NULL DEFAULT 0,
+    zone TEXT NOT NULL DEFAULT '',
+    zone_signed BOOLEAN NOT NULL DEFAULT 0,
+    tlsa TEXT NOT NULL DEFAULT '', -- newline separated
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_mx_records_check_id ON mx_records(check_id);
+
+-- what it adds up to; see dane.go
+ALTER TABLE dns_checks ADD COLUMN dane TEXT;
@@ -0,0 +1,13 @@
+-- what changed in a domain's DS and DNSKEY sets from one check to the
+-- next: rollovers, DS records coming and going; see events.go
+CREATE TABLE IF NOT EXISTS key_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    check_id INTEGER NOT NULL REFERENCES dns_checks(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_key_events_created_at ON key_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_key_events_domain_id ON key_events(domain_id);
@@ -0,0 +1,4 @@
+-- the probes besides DS that failed on a check, e.g. "cds: i/o timeout".
+-- They don't fail the check, and what they'd have found is carried over
+-- from the one before.
+ALTER TABLE dns_checks ADD COLUMN probe_errors TEXT;
@@ -0,0 +1,3 @@
+-- the DS lookups each resolver answered that were compared with another
+-- resolver's, which is what disagreements are out of
+ALTER TABLE resolvers ADD COLUMN ds_lookups INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// nsAnswer is what one of a zone's own nameservers said, asked directly,
+// about the zone's DNSKEY and SOA. Serial is -1 when there wasn't one.
+type nsAnswer struct {
+	Nameserver string
+	Address    string
+	KeyTags    []uint16
+	Signed     bool // both sets came with signatures
+	Serial     int64
+	Err        string
+
+	keys string // the DNSKEY set, canonicalized for comparison; not stored
+}
+
+// nsNames returns the hosts in an NS set, canonicalized and sorted.
+func nsNames(set []dns.RR) []string {
+	var hosts []string
+	for _, rr := range set {
+		if ns, ok := rr.(*dns.NS); ok {
+			hosts = append(hosts, dns.CanonicalName(ns.Ns))
+		}
+	}
+	slices.Sort(hosts)
+	return slices.Compact(hosts)
+}
+
+// probeNameservers asks each of domain's nameservers, at every IPv4
+// address it has, for the zone's DNSKEY and SOA. Failing to reach one
+// server is noted in its answer rather than failing the whole probe.
+func probeNameservers(ctx context.Context, domain string, hosts []string) []nsAnswer {
+	var ret []nsAnswer
+	for _, host := range hosts {
+		r, err := query(ctx, pool.one(), host, dns.TypeA)
+		if err == nil && r.Rcode != dns.RcodeSuccess {
+			err = rcodeError("a", r.Rcode)
+		}
+		if err != nil {
+			ret = append(ret, nsAnswer{Nameserver: host, Serial: -1, Err: err.Error()})
+			continue
+		}
+		addrs := addrsOf(r.Answer, host)
+		if len(addrs) == 0 {
+			ret = append(ret, nsAnswer{Nameserver: host, Serial: -1, Err: "no IPv4 address"})
+			continue
+		}
+		slices.Sort(addrs)
+		for _, addr := range addrs {
+			ret = append(ret, askNameserver(ctx, domain, host, addr))
+		}
+	}
+	return ret
+}
+
+func askNameserver(ctx context.Context, domain, host, addr string) nsAnswer {
+	a := nsAnswer{Nameserver: host, Address: addr, Serial: -1}
+	server := net.JoinHostPort(addr, authPort)
+
+	r, err := askAuthoritative(ctx, server, domain, dns.TypeDNSKEY)
+	if err != nil {
+		a.Err = err.Error()
+		return a
+	}
+	keys, keySigs := rrsetOf(r.Answer, domain, dns.TypeDNSKEY)
+	var canon []string
+	for _, rr := range keys {
+		k := rr.(*dns.DNSKEY)
+		a.KeyTags = append(a.KeyTags, k.KeyTag())
+		canon = append(canon, fmt.Sprintf("%d %d %d %s",
+			k.Flags, k.Protocol, k.Algorithm, k.PublicKey))
+	}
+	slices.Sort(a.KeyTags)
+	slices.Sort(canon)
+	a.keys = strings.Join(canon, "\n")
+
+	r, err = askAuthoritative(ctx, server, domain, dns.TypeSOA)
+	if err != nil {
+		a.Err = err.Error()
+		return a
+	}
+	soa, soaSigs := rrsetOf(r.Answer, domain, dns.TypeSOA)
+	if len(soa) > 0 {
+		a.Serial = int64(soa[0].(*dns.SOA).Serial)
+	}
+	a.Signed = len(keySigs) > 0 && len(soaSigs) > 0
+	return a
+}
+
+// askAuthoritative sends a non-recursive DO-bit query straight to one of
+// a zone's servers.
+func askAuthoritative(ctx context.Context, server, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = false
+	m.SetEdns0(4096, true)
+	r, err := exchange(ctx, server, m)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, rcodeError(strings.ToLower(dns.TypeToString[qtype]), r.Rcode)
+	}
+	return r, nil
+}
+
+// nsProblem describes how the nameservers that answered disagree, or
+// returns "" if they don't. Unreachable servers aren't held against the
+// zone here, and neither are SOA serials: secondaries lag behind the
+// primary for a while after every update, and a zone mid-update shouldn't
+// get a new history row each way. serialSkew is for showing those.
+func nsProblem(answers []nsAnswer) string {
+	var (
+		problems []string
+		unsigned []string
+		keysets  = make(map[string][]string)
+		order    []string
+	)
+	for _, a := range answers {
+		if a.Err != "" {
+			continue
+		}
+		name := a.label()
+		if !a.Signed {
+			unsigned = append(unsigned, name)
+		}
+		if _, ok := keysets[a.keys]; !ok {
+			order = append(order, a.keys)
+		}
+		keysets[a.keys] = append(keysets[a.keys], name)
+	}
+
+	if len(order) > 1 {
+		var groups []string
+		for _, k := range order {
+			groups = append(groups, strings.Join(keysets[k], ", "))
+		}
+		problems = append(problems, "different DNSKEY sets from "+
+			strings.Join(groups, " vs. "))
+	}
+	if len(unsigned) > 0 {
+		problems = append(problems, "unsigned answers from "+
+			strings.Join(unsigned, ", "))
+	}
+	return strings.Join(problems, "; ")
+}
+
+// serialSkew reports whether the nameservers that answered gave different
+// SOA serials.
+func serialSkew(answers []nsAnswer) bool {
+	serials := make(map[int64]bool)
+	for _, a := range answers {
+		if a.Err == "" && a.Serial >= 0 {
+			serials[a.Serial] = true
+		}
+	}
+	return len(serials) > 1
+}
+
+func (a nsAnswer) label() string {
+	if a.Address == "" {
+		return a.Nameserver
+	}
+	return a.Nameserver + " (" + a.Address + ")"
+}
+
+func loadNSNames(ctx context.Context, db *sql.DB, checkID int) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT name FROM ns_records WHERE check_id = ? ORDER BY name`,
+		checkID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		ret = append(ret, name)
+	}
+	return ret, rows.Err()
+}
+
+func insertNSNames(ctx context.Context, tx *sql.Tx, checkID int64, names []string) error {
+	for _, n := range names {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO ns_records(check_id, name) VALUES(?, ?)`,
+			checkID, n,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadNSAnswers(ctx context.Context, db *sql.DB, checkID int) ([]nsAnswer, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT nameserver, address, key_tags, signed, serial, error
+		FROM ns_answers
+		WHERE check_id = ?
+		ORDER BY id`,
+		checkID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []nsAnswer
+	for rows.Next() {
+		var (
+			a      nsAnswer
+			tags   string
+			serial sql.NullInt64
+		)
+		if err := rows.Scan(
+			&a.Nameserver, &a.Address, &tags, &a.Signed, &serial, &a.Err,
+		); err != nil {
+			return nil, err
+		}
+		for _, f := range strings.Fields(tags) {
+			tag, err := strconv.ParseUint(f, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("key tags %q: %w", tags, err)
+			}
+			a.KeyTags = append(a.KeyTags, uint16(tag))
+		}
+		a.Serial = -1
+		if serial.Valid {
+			a.Serial = serial.Int64
+		}
+		ret = append(ret, a)
+	}
+	return ret, rows.Err()
+}
+
+func insertNSAnswers(ctx context.Context, tx *sql.Tx, checkID int64, answers []nsAnswer) error {
+	for _, a := range answers {
+		var (
+			tags   []string
+			serial sql.NullInt64
+		)
+		for _, t := range a.KeyTags {
+			tags = append(tags, strconv.Itoa(int(t)))
+		}
+		if a.Serial >= 0 {
+			serial = sql.NullInt64{Int64: a.Serial, Valid: true}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO ns_answers(check_id, nameserver, address, key_tags,
+				signed, serial, error)
+			VALUES(?, ?, ?, ?, ?, ?, ?)`,
+			checkID, a.Nameserver, a.Address, strings.Join(tags, " "),
+			a.Signed, serial, a.Err,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
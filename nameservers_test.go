@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNSProblem(t *testing.T) {
+	ns := func(host, keys string, signed bool, serial int64) nsAnswer {
+		return nsAnswer{Nameserver: host, Address: "192.0.2.1", keys: keys,
+			Signed: signed, Serial: serial}
+	}
+	for _, tc := range []struct {
+		name    string
+		answers []nsAnswer
+		want    []string // substrings; none means consistent
+	}{
+		{"consistent", []nsAnswer{
+			ns("a.", "k1", true, 1), ns("b.", "k1", true, 1),
+		}, nil},
+		{"different keys", []nsAnswer{
+			ns("a.", "k1", true, 1), ns("b.", "k2", true, 1),
+		}, []string{"different DNSKEY sets from a. (192.0.2.1) vs. b. (192.0.2.1)"}},
+		{"unsigned", []nsAnswer{
+			ns("a.", "k1", true, 1), ns("b.", "k1", false, 1),
+		}, []string{"unsigned answers from b."}},
+		{"serials", []nsAnswer{
+			ns("a.", "k1", true, 1), ns("b.", "k1", true, 2),
+		}, nil},
+		{"unreachable", []nsAnswer{
+			ns("a.", "k1", true, 1), {Nameserver: "b.", Serial: -1, Err: "timeout"},
+		}, nil},
+	} {
+		got := nsProblem(tc.answers)
+		if len(tc.want) == 0 && got != "" {
+			t.Errorf("%s: want no problem, got %q", tc.name, got)
+		}
+		for _, w := range tc.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: %q doesn't mention %q", tc.name, got, w)
+			}
+		}
+	}
+
+	if serialSkew([]nsAnswer{ns("a.", "k1", true, 1), ns("b.", "k1", true, 1)}) {
+		t.Error("same serials skewed")
+	}
+	if !serialSkew([]nsAnswer{ns("a.", "k1", true, 1), ns("b.", "k1", true, 2)}) {
+		t.Error("different serials not skewed")
+	}
+}
+
+// TestCheckDomainNameservers points good.test at two nameservers on
+// loopback, one of which is halfway through a migration to new keys.
+func TestCheckDomainNameservers(t *testing.T) {
+	z, keys := signedTree(t)
+	good := keys["good.test."]
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	soa := func(serial uint32) *dns.SOA {
+		return &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "good.test.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+			Ns:     "ns1.good.test.",
+			Mbox:   "hostmaster.good.test.",
+			Serial: serial,
+		}
+	}
+	z.add(
+		mustRR(t, "good.test. 300 IN NS ns1.good.test."),
+		mustRR(t, "good.test. 300 IN NS ns2.good.test."),
+		mustRR(t, "ns1.good.test. 300 IN A 127.0.0.2"),
+		mustRR(t, "ns2.good.test. 300 IN A 127.0.0.3"),
+	)
+
+	ns1 := newTestZones()
+	ns1.addSigned(t, good, good.key)
+	ns1.addSigned(t, good, soa(1))
+
+	// a new provider serving its own key, and not signing the SOA yet
+	other := newTestKey(t, "good.test.", 257)
+	ns2 := newTestZones()
+	ns2.addSigned(t, other, other.key)
+	ns2.add(soa(2))
+
+	_, port, _ := net.SplitHostPort(addr)
+	serveDNSOn(t, "127.0.0.2:"+port, ns1)
+	serveDNSOn(t, "127.0.0.3:"+port, ns2)
+	oldPort := authPort
+	authPort = port
+	t.Cleanup(func() { authPort = oldPort })
+
+	db := testDB(t)
+	id := insertDomain(t, db, "good.test", 1)
+	ctx := context.Background()
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	lastID, last, err := lastCheck(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Provider != providerSelf ||
+		!reflect.DeepEqual(last.Nameservers, []string{"ns1.good.test.", "ns2.good.test."}) {
+		t.Errorf("NS set %v attributed to %q", last.Nameservers, last.Provider)
+	}
+	for _, want := range []string{
+		"different DNSKEY sets",
+		"unsigned answers from ns2.good.test. (127.0.0.3)",
+	} {
+		if !strings.Contains(last.NSProblem, want) {
+			t.Errorf("problem %q doesn't mention %q", last.NSProblem, want)
+		}
+	}
+	if strings.Contains(last.NSProblem, "serial") {
+		t.Errorf("serials in problem %q", last.NSProblem)
+	}
+
+	answers, err := loadNSAnswers(ctx, db, lastID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("want 2 nameserver answers, got %+v", answers)
+	}
+	a, b := answers[0], answers[1]
+	if a.Nameserver != "ns1.good.test." || !a.Signed || a.Serial != 1 ||
+		len(a.KeyTags) != 1 || a.KeyTags[0] != good.key.KeyTag() {
+		t.Errorf("ns1: %+v", a)
+	}
+	if b.Nameserver != "ns2.good.test." || b.Signed || b.Serial != 2 ||
+		len(b.KeyTags) != 1 || b.KeyTags[0] != other.key.KeyTag() {
+		t.Errorf("ns2: %+v", b)
+	}
+	if !serialSkew(answers) {
+		t.Error("serials 1 and 2 not skewed")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// providers.json maps DNS operators to the nameserver hostnames they hand
+// out, as suffixes; a * matches anything within one label, for Route 53's
+// awsdns-NN names. Set PROVIDERS_FILE to use an edited copy, then run
+// -update-providers to re-attribute checks already on record.
+//
+//go:embed providers.json
+var bundledProviders []byte
+
+// what attribute calls domains no single known operator serves
+const (
+	providerSelf     = "self-hosted" // every nameserver is inside the domain
+	providerMultiple = "multiple"    // nameservers from more than one operator
+	providerOther    = "other"       // nobody in the mapping
+)
+
+type providerRule struct {
+	name   string
+	labels []string
+}
+
+// set from PROVIDERS_FILE in main; tests swap it out
+var providerRules = mustProviders(bundledProviders)
+
+func mustProviders(data []byte) []providerRule {
+	rules, err := parseProviders(data)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+func parseProviders(data []byte) ([]providerRule, error) {
+	var m map[string][]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	var rules []providerRule
+	for name, suffixes := range m {
+		for _, s := range suffixes {
+			labels := dns.SplitDomainName(strings.ToLower(s))
+			if len(labels) == 0 {
+				return nil, fmt.Errorf("%s: empty suffix", name)
+			}
+			for _, l := range labels {
+				if _, err := path.Match(l, ""); err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", name, s, err)
+				}
+			}
+			rules = append(rules, providerRule{name: name, labels: labels})
+		}
+	}
+	// longest suffix first, so more specific rules win
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].labels) != len(rules[j].labels) {
+			return len(rules[i].labels) > len(rules[j].labels)
+		}
+		return rules[i].name < rules[j].name
+	})
+	return rules, nil
+}
+
+func loadProviders(file string) ([]providerRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parseProviders(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return rules, nil
+}
+
+// providerOf names the operator behind a nameserver host, or "".
+func providerOf(host string) string {
+	labels := dns.SplitDomainName(strings.ToLower(host))
+rules:
+	for _, r := range providerRules {
+		if len(labels) < len(r.labels) {
+			continue
+		}
+		tail := labels[len(labels)-len(r.labels):]
+		for i, pat := range r.labels {
+			if ok, _ := path.Match(pat, tail[i]); !ok {
+				continue rules
+			}
+		}
+		return r.name
+	}
+	return ""
+}
+
+// attribute names who runs DNS for domain, going by its NS set. It's ""
+// when there's no NS set to go on.
+func attribute(domain string, hosts []string) string {
+	seen := make(map[string]bool)
+	for _, h := range hosts {
+		p := providerOf(h)
+		switch {
+		case p != "":
+		case dns.IsSubDomain(dns.Fqdn(domain), dns.Fqdn(h)):
+			p = providerSelf
+		default:
+			p = providerOther
+		}
+		seen[p] = true
+	}
+	switch len(seen) {
+	case 0:
+		return ""
+	case 1:
+		for p := range seen {
+			return p
+		}
+	}
+	return providerMultiple
+}
+
+// updateProviders re-attributes every check with a recorded NS set using
+// the current mapping.
+func updateProviders(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.id, d.name, n.name
+		FROM ns_records n
+		JOIN dns_checks c ON c.id = n.check_id
+		JOIN domains d ON d.id = c.domain_id
+		ORDER BY c.id`)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		order  []int
+		domain = make(map[int]string)
+		hosts  = make(map[int][]string)
+	)
+	for rows.Next() {
+		var (
+			id         int
+			name, host string
+		)
+		if err := rows.Scan(&id, &name, &host); err != nil {
+			return err
+		}
+		if _, ok := domain[id]; !ok {
+			order = append(order, id)
+			domain[id] = name
+		}
+		hosts[id] = append(hosts[id], host)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	for _, id := range order {
+		p := attribute(domain[id], hosts[id])
+		r, err := tx.ExecContext(ctx, `
+			UPDATE dns_checks SET provider = ?
+			WHERE id = ? AND COALESCE(provider, '') != ?`,
+			p, id, p,
+		)
+		if err != nil {
+			return fmt.Errorf("update check %d: %w", id, err)
+		}
+		n, err := r.RowsAffected()
+		if err != nil {
+			return err
+		}
+		changed += int(n)
+	}
+	slog.Info("providers updated", "checks", len(order), "changed", changed)
+	return tx.Commit()
+}
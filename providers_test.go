@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAttribute(t *testing.T) {
+	for _, tc := range []struct {
+		domain string
+		hosts  []string
+		want   string
+	}{
+		{"example.com", []string{"ada.ns.cloudflare.com.", "bob.ns.cloudflare.com."}, "Cloudflare"},
+		{"example.com", []string{"ns-1.awsdns-01.com.", "ns-2.awsdns-22.co.uk."}, "Route 53"},
+		{"example.com", []string{"NS-3.AWSDNS-03.ORG"}, "Route 53"},
+		{"example.com", []string{"ns1.example.com.", "ns2.example.com."}, providerSelf},
+		{"example.com", []string{"ns1.example.com.", "a1-1.akam.net."}, providerMultiple},
+		{"example.com", []string{"ns1.example.net."}, providerOther},
+		{"example.com", []string{"awsdns-01.com.evil.net."}, providerOther},
+		{"example.com", nil, ""},
+	} {
+		if got := attribute(tc.domain, tc.hosts); got != tc.want {
+			t.Errorf("%s %v: want %q got %q", tc.domain, tc.hosts, tc.want, got)
+		}
+	}
+}
+
+func TestParseProviders(t *testing.T) {
+	if _, err := parseProviders([]byte(`{"Broken": ["[.net"]}`)); err == nil {
+		t.Error("accepted a bad pattern")
+	}
+	rules, err := parseProviders([]byte(`{"Big": ["dns.net"], "Small": ["eu.dns.net"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := providerRules
+	providerRules = rules
+	t.Cleanup(func() { providerRules = old })
+	if p := providerOf("ns1.eu.dns.net."); p != "Small" {
+		t.Errorf("the longer suffix should win, got %q", p)
+	}
+}
+
+func TestProvidersReattributeAndRatios(t *testing.T) {
+	db := testDB(t)
+	names := seedDomains(t, db, 3)
+	now := time.Now()
+	for i, name := range names {
+		insertCheck(t, db, name, now, i == 0)
+		if _, err := db.Exec(`
+			INSERT INTO ns_records(check_id, name)
+			SELECT c.id, 'ns1.example-dns.net.' FROM dns_checks c
+			JOIN domains d ON d.id = c.domain_id WHERE d.name = ?`,
+			name,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	if err := updateProviders(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	shares, err := providerRatios(ctx, db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 1 || shares[0].Name != providerOther || shares[0].Domains != 3 {
+		t.Fatalf("before the mapping knows it: %+v", shares)
+	}
+
+	// someone edits providers.json
+	rules, err := parseProviders([]byte(`{"Example DNS": ["example-dns.net"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := providerRules
+	providerRules = rules
+	t.Cleanup(func() { providerRules = old })
+	if err := updateProviders(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	shares, err = providerRatios(ctx, db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 1 || shares[0].Name != "Example DNS" {
+		t.Fatalf("after: %+v", shares)
+	}
+	if p := shares[0].Pct; p < 33 || p > 34 {
+		t.Errorf("pct %.1f not 33.3", p)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+)
+
+// public_suffix_list.dat is the Public Suffix List
+// (https://publicsuffix.org/list/), which says where registries hand out
+// names: bbc.co.uk is registered under co.uk, not uk. Only its ICANN
+// section is used. The private section lists the likes of blogspot.com
+// and github.io, which we'd rather track as domains than split up. Set
+// PSL_FILE to use a newer copy, then run -update-suffixes to re-split
+// domains already in the database.
+//
+//go:embed public_suffix_list.dat
+var bundledSuffixes []byte
+
+type suffixList struct {
+	rules      map[string]bool // co.uk
+	wildcards  map[string]bool // *.ck, stored as ck
+	exceptions map[string]bool // !www.ck, stored as www.ck
+}
+
+// set from PSL_FILE in main; tests swap it out
+var suffixes = mustSuffixes(bundledSuffixes)
+
+func mustSuffixes(data []byte) *suffixList {
+	l, err := parseSuffixes(data)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func parseSuffixes(data []byte) (*suffixList, error) {
+	l := &suffixList{
+		rules:      make(map[string]bool),
+		wildcards:  make(map[string]bool),
+		exceptions: make(map[string]bool),
+	}
+	var (
+		sc    = bufio.NewScanner(bytes.NewReader(data))
+		icann bool
+		seen  bool
+		line  int
+	)
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(text, "// ===BEGIN ICANN DOMAINS==="):
+			icann, seen = true, true
+			continue
+		case strings.HasPrefix(text, "// ===END ICANN DOMAINS==="):
+			icann = false
+			continue
+		case !icann || text == "" || strings.HasPrefix(text, "//"):
+			continue
+		}
+
+		rule := strings.ToLower(strings.Fields(text)[0])
+		kind := l.rules
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			kind, rule = l.exceptions, rule[1:]
+		case strings.HasPrefix(rule, "*."):
+			kind, rule = l.wildcards, rule[2:]
+		}
+		for _, lab := range strings.Split(rule, ".") {
+			if lab == "" || strings.Contains(lab, "*") {
+				return nil, fmt.Errorf("line %d: bad rule %q", line, text)
+			}
+		}
+		// the list spells internationalized suffixes in Unicode, but the
+		// names we track are in ASCII
+		ascii, err := idna.Lookup.ToASCII(rule)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		kind[ascii] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !seen {
+		return nil, fmt.Errorf("no ICANN section")
+	}
+	return l, nil
+}
+
+func loadSuffixes(file string) (*suffixList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	l, err := parseSuffixes(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return l, nil
+}
+
+// split returns the public suffix name falls under (co.uk for
+// www.bbc.co.uk) and the registrable domain, one label longer (bbc.co.uk).
+// Names under no listed suffix get their last label as the suffix, as the
+// list's default rule says. registrable is "" when name is itself a
+// suffix.
+func (l *suffixList) split(name string) (suffix, registrable string) {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	if len(labels) == 0 {
+		return "", ""
+	}
+
+	n := 1
+	for i := range labels {
+		s := strings.Join(labels[i:], ".")
+		if l.exceptions[s] {
+			n = len(labels) - i - 1
+			break
+		}
+		if l.rules[s] ||
+			(i+1 < len(labels) && l.wildcards[strings.Join(labels[i+1:], ".")]) {
+			n = len(labels) - i
+			break
+		}
+	}
+
+	suffix = strings.Join(labels[len(labels)-n:], ".")
+	if n < len(labels) {
+		registrable = strings.Join(labels[len(labels)-n-1:], ".")
+	}
+	return suffix, registrable
+}
+
+// updateSuffixes fills in public_suffix and registrable for every domain
+// that doesn't have them yet, or for every domain if all is set.
+func updateSuffixes(ctx context.Context, db *sql.DB, all bool) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name, COALESCE(public_suffix, ''), COALESCE(registrable, '')
+		FROM domains
+		WHERE ? OR public_suffix IS NULL`,
+		all,
+	)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	type split struct {
+		id                  int
+		suffix, registrable string
+	}
+	var todo []split
+	for rows.Next() {
+		var (
+			id                   int
+			name, oldSuf, oldReg string
+		)
+		if err := rows.Scan(&id, &name, &oldSuf, &oldReg); err != nil {
+			return err
+		}
+		suf, reg := suffixes.split(name)
+		if all && suf == oldSuf && reg == oldReg {
+			continue
+		}
+		todo = append(todo, split{id, suf, reg})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if len(todo) == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range todo {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE domains SET public_suffix = ?, registrable = ? WHERE id = ?`,
+			s.suffix, s.registrable, s.id,
+		); err != nil {
+			return fmt.Errorf("update domain %d: %w", s.id, err)
+		}
+	}
+	slog.Info("public suffixes updated", "domains", len(todo))
+	return tx.Commit()
+}
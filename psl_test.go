@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSplitSuffix(t *testing.T) {
+	for _, tc := range []struct {
+		name, suffix, registrable string
+	}{
+		{"example.com", "com", "example.com"},
+		{"www.bbc.co.uk", "co.uk", "bbc.co.uk"},
+		{"BBC.CO.UK.", "co.uk", "bbc.co.uk"},
+		{"co.uk", "co.uk", ""},
+		{"foo.bar.ck", "bar.ck", "foo.bar.ck"}, // *.ck
+		{"www.ck", "ck", "www.ck"},             // !www.ck
+		{"a.city.kawasaki.jp", "kawasaki.jp", "city.kawasaki.jp"},
+		{"xn--80aswg.xn--p1ai", "xn--p1ai", "xn--80aswg.xn--p1ai"}, // рф
+		{"www.xn--j6w193g", "xn--j6w193g", "www.xn--j6w193g"},      // 香港
+		{"a.b.xn--55qx5d.cn", "xn--55qx5d.cn", "b.xn--55qx5d.cn"},  // 公司.cn
+		{"foo.blogspot.com", "com", "blogspot.com"},                // private section
+		{"good.test", "test", "good.test"},                         // default rule
+	} {
+		suf, reg := suffixes.split(tc.name)
+		if suf != tc.suffix || reg != tc.registrable {
+			t.Errorf("%s: want %s/%s got %s/%s",
+				tc.name, tc.suffix, tc.registrable, suf, reg)
+		}
+	}
+}
+
+func TestParseSuffixes(t *testing.T) {
+	if _, err := parseSuffixes([]byte("com\n")); err == nil {
+		t.Error("accepted a list with no ICANN section")
+	}
+	if _, err := parseSuffixes([]byte(
+		"// ===BEGIN ICANN DOMAINS===\nfoo.*.com\n// ===END ICANN DOMAINS===\n",
+	)); err == nil {
+		t.Error("accepted a wildcard in the middle")
+	}
+}
+
+func TestUpdateSuffixes(t *testing.T) {
+	db := testDB(t)
+	insertDomain(t, db, "bbc.co.uk", 1)
+	insertDomain(t, db, "example.com", 2)
+	ctx := context.Background()
+	if err := updateSuffixes(ctx, db, false); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(name string) (suffix, registrable string) {
+		t.Helper()
+		if err := db.QueryRow(
+			`SELECT public_suffix, registrable FROM domains WHERE name = ?`,
+			name,
+		).Scan(&suffix, &registrable); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if suf, reg := get("bbc.co.uk"); suf != "co.uk" || reg != "bbc.co.uk" {
+		t.Errorf("bbc.co.uk split as %s/%s", suf, reg)
+	}
+
+	// a list that doesn't know about co.uk
+	l, err := parseSuffixes([]byte(
+		"// ===BEGIN ICANN DOMAINS===\nuk\ncom\n// ===END ICANN DOMAINS===\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := suffixes
+	suffixes = l
+	t.Cleanup(func() { suffixes = old })
+
+	if err := updateSuffixes(ctx, db, false); err != nil {
+		t.Fatal(err)
+	}
+	if suf, _ := get("bbc.co.uk"); suf != "co.uk" {
+		t.Error("split a domain that was already split")
+	}
+	if err := updateSuffixes(ctx, db, true); err != nil {
+		t.Fatal(err)
+	}
+	if suf, reg := get("bbc.co.uk"); suf != "uk" || reg != "co.uk" {
+		t.Errorf("bbc.co.uk re-split as %s/%s", suf, reg)
+	}
+
+	base, tld := domainParts("bbc.co.uk", "co.uk")
+	if base != "bbc" || tld != "co.uk" {
+		t.Errorf("domainParts: %s . %s", base, tld)
+	}
+}
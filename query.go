@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolvers are plain "host:port" addresses spoken to over UDP (falling
+// back to TCP), "tls://host[:port]" for DNS over TLS (RFC 7858), or
+// "https://host/path" for DNS over HTTPS (RFC 8484).
+var (
+	tlsConfig  *tls.Config // nil means system roots; tests swap it out
+	httpClient = http.DefaultClient
+
+	// how long a DoH query gets, the same as dns.Client gives UDP and DoT
+	dohTimeout = 2 * time.Second
+)
+
+// parseResolvers reads a comma-separated resolver list. Plain addresses
+// without a port get :53.
+func parseResolvers(s string) ([]string, error) {
+	var ret []string
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		switch {
+		case r == "":
+			continue
+		case strings.HasPrefix(r, "https://"):
+			u, err := url.Parse(r)
+			if err != nil {
+				return nil, err
+			}
+			if u.Host == "" {
+				return nil, fmt.Errorf("%s: no host", r)
+			}
+		case strings.HasPrefix(r, "tls://"):
+			if strings.TrimPrefix(r, "tls://") == "" {
+				return nil, fmt.Errorf("%s: no host", r)
+			}
+		case strings.Contains(r, "://"):
+			return nil, fmt.Errorf("%s: unsupported transport", r)
+		default:
+			r, _ = hostPort(r, "53")
+		}
+		ret = append(ret, r)
+	}
+	// lookupDS wants two opinions
+	if len(ret) < 2 {
+		return nil, fmt.Errorf("need at least two resolvers, got %d", len(ret))
+	}
+	return ret, nil
+}
+
+// hostPort adds port to addr unless it has one already, and also returns
+// the host on its own, without an IPv6 literal's brackets.
+func hostPort(addr, port string) (hostport, host string) {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return addr, h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, port), host
+}
+
+// query sends a single DO-bit query for name/qtype to server, so signed
+// answers come back with their RRSIGs.
+func query(ctx context.Context, server, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.SetEdns0(4096, true)
+	r, _, err := ask(ctx, server, m)
+	return r, err
+}
+
+// queryCD is query with Checking Disabled, for the chain walk in
+// validate.go: a validating resolver answers SERVFAIL for data that
+// doesn't check out, and we want the data, to find out why.
+func queryCD(ctx context.Context, server, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.SetEdns0(4096, true)
+	m.CheckingDisabled = true
+	r, _, err := ask(ctx, server, m)
+	return r, err
+}
+
+// exchange sends m to server, retrying over TCP if the answer comes back
+// truncated, which matters for big DNSKEY sets. The TCP answer keeps TC
+// set, to show it took a retry. Every query we send goes
+// through here, and so through the rate limiter.
+func exchange(ctx context.Context, server string, m *dns.Msg) (*dns.Msg, error) {
+	var (
+		name  = m.Question[0].Name
+		qtype = m.Question[0].Qtype
+		c     = new(dns.Client)
+	)
+
+	if err := limiter.wait(ctx, server); err != nil {
+		return nil, fmt.Errorf("%s %s @%s: %w",
+			name, dns.TypeToString[qtype], server, err)
+	}
+
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		r, err := exchangeHTTPS(ctx, server, m)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s @%s: %w",
+				name, dns.TypeToString[qtype], server, err)
+		}
+		return r, nil
+
+	case strings.HasPrefix(server, "tls://"):
+		addr, host := hostPort(strings.TrimPrefix(server, "tls://"), "853")
+		c.Net = "tcp-tls"
+		c.TLSConfig = &tls.Config{ServerName: host}
+		if tlsConfig != nil {
+			c.TLSConfig = tlsConfig.Clone()
+			c.TLSConfig.ServerName = host
+		}
+		// a stream transport never truncates
+		r, _, err := c.ExchangeContext(ctx, m, addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s @%s: %w",
+				name, dns.TypeToString[qtype], server, err)
+		}
+		return r, nil
+	}
+
+	r, _, err := c.ExchangeContext(ctx, m, server)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s @%s: %w",
+			name, dns.TypeToString[qtype], server, err)
+	}
+
+	if r.Truncated {
+		if err := limiter.wait(ctx, server); err != nil {
+			return nil, fmt.Errorf("%s %s @%s (tcp): %w",
+				name, dns.TypeToString[qtype], server, err)
+		}
+		c.Net = "tcp"
+		r, _, err = c.ExchangeContext(ctx, m, server)
+		if err != nil {
+			return nil, failf(failTruncated, "%s %s @%s (tcp): %w",
+				name, dns.TypeToString[qtype], server, err)
+		}
+		// the TCP answer is whole; TC stays set so callers can tell the
+		// UDP one wasn't (see resolverAnswer.TC)
+		r.Truncated = true
+	}
+
+	return r, nil
+}
+
+// exchangeHTTPS POSTs m to a DoH endpoint in wire format.
+func exchangeHTTPS(ctx context.Context, url string, m *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 4.1: use ID 0 so answers are cache friendly
+	q := m.Copy()
+	q.Id = 0
+	wire, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dohTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(wire))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, fmt.Errorf("doh: %w", err)
+	}
+	r.Id = m.Id
+	return r, nil
+}
+
+// rrsetOf pulls the records of type qtype owned by name out of rrs, along
+// with the RRSIGs that cover them.
+func rrsetOf(rrs []dns.RR, name string, qtype uint16) (set []dns.RR, sigs []*dns.RRSIG) {
+	name = dns.CanonicalName(name)
+	for _, rr := range rrs {
+		h := rr.Header()
+		if dns.CanonicalName(h.Name) != name {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == qtype {
+				sigs = append(sigs, sig)
+			}
+			continue
+		}
+		if h.Rrtype == qtype {
+			set = append(set, rr)
+		}
+	}
+	return
+}
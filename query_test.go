@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohHandler answers RFC 8484 POSTs out of h.
+type dohHandler struct{ h dns.Handler }
+
+func (d dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost ||
+		r.Header.Get("Content-Type") != "application/dns-message" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	req := new(dns.Msg)
+	if err := req.Unpack(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rw := &bufWriter{}
+	d.h.ServeDNS(rw, req)
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(rw.buf.Bytes())
+}
+
+// bufWriter is just enough of a dns.ResponseWriter to capture one reply.
+type bufWriter struct {
+	dns.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (b *bufWriter) WriteMsg(m *dns.Msg) error {
+	wire, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	b.buf.Write(wire)
+	return nil
+}
+
+// serveEncrypted starts DoT and DoH stand-ins for h sharing one test
+// certificate, and makes the package trust it.
+func serveEncrypted(t *testing.T, h dns.Handler) (dot, doh string) {
+	t.Helper()
+	hs := httptest.NewTLSServer(dohHandler{h})
+	t.Cleanup(hs.Close)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", hs.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{Listener: l, Net: "tcp-tls", Handler: h}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		srv.Shutdown()
+		<-done
+	})
+
+	oldTLS, oldHTTP := tlsConfig, httpClient
+	tlsConfig = hs.Client().Transport.(*http.Transport).TLSClientConfig
+	httpClient = hs.Client()
+	t.Cleanup(func() { tlsConfig, httpClient = oldTLS, oldHTTP })
+
+	return "tls://" + l.Addr().String(), hs.URL + "/dns-query"
+}
+
+func TestEncryptedTransports(t *testing.T) {
+	z, keys := signedTree(t)
+	dot, doh := serveEncrypted(t, z)
+
+	usePool(t, dot, doh)
+
+	ctx := context.Background()
+	want := dsRecords([]dns.RR{keys["good.test."].ds()})
+	for _, server := range []string{dot, doh} {
+		r, err := query(ctx, server, "good.test", dns.TypeDS)
+		if err != nil {
+			t.Fatalf("%s: %v", server, err)
+		}
+		if got := dsRecords(r.Answer); !sameDS(got, want) {
+			t.Errorf("%s: want %v got %v", server, want, got)
+		}
+	}
+
+	// one of each, so lookupDS has to agree across transports
+	rrs, answers, err := lookupDS(ctx, "good.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dsRecords(rrs); !sameDS(got, want) {
+		t.Errorf("lookupDS: want %v got %v", want, got)
+	}
+	for _, a := range answers {
+		if a.Rcode != dns.RcodeSuccess || len(a.Answer) == 0 {
+			t.Errorf("%s: %+v", a.Resolver, a)
+		}
+	}
+
+	if v := validateDomain(ctx, doh, "good.test"); v.Verdict != verdictSecure {
+		t.Errorf("validate over doh: %+v", v)
+	}
+}
+
+func TestHTTPSTimeout(t *testing.T) {
+	stuck := make(chan struct{})
+	hs := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stuck
+	}))
+	t.Cleanup(hs.Close)
+	t.Cleanup(func() { close(stuck) })
+
+	oldHTTP, oldTimeout := httpClient, dohTimeout
+	httpClient, dohTimeout = hs.Client(), 50*time.Millisecond
+	t.Cleanup(func() { httpClient, dohTimeout = oldHTTP, oldTimeout })
+
+	_, err := query(context.Background(), hs.URL+"/dns-query", "good.test", dns.TypeDS)
+	if code := classify(err); code != failTimeout {
+		t.Fatalf("want %s, got %s: %v", failTimeout, code, err)
+	}
+}
+
+func TestParseResolvers(t *testing.T) {
+	got, err := parseResolvers("8.8.8.8, tls://1.1.1.1, https://dns.google/dns-query,[2001:db8::1]:5353," +
+		"tls://[2620:fe::fe],[2001:db8::2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"8.8.8.8:53",
+		"tls://1.1.1.1",
+		"https://dns.google/dns-query",
+		"[2001:db8::1]:5353",
+		"tls://[2620:fe::fe]",
+		"[2001:db8::2]:53",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q got %q", want, got)
+	}
+
+	// what exchange dials and checks the certificate against
+	for _, tc := range [][3]string{
+		{"1.1.1.1", "1.1.1.1:853", "1.1.1.1"},
+		{"dns.quad9.net:8853", "dns.quad9.net:8853", "dns.quad9.net"},
+		{"[2620:fe::fe]", "[2620:fe::fe]:853", "2620:fe::fe"},
+		{"2620:fe::fe", "[2620:fe::fe]:853", "2620:fe::fe"},
+		{"[2620:fe::fe]:853", "[2620:fe::fe]:853", "2620:fe::fe"},
+	} {
+		addr, host := hostPort(tc[0], "853")
+		if addr != tc[1] || host != tc[2] {
+			t.Errorf("%s: want %s %s got %s %s", tc[0], tc[1], tc[2], addr, host)
+		}
+	}
+
+	for _, bad := range []string{"8.8.8.8", "quic://1.1.1.1,8.8.8.8", "tls://,8.8.8.8"} {
+		if _, err := parseResolvers(bad); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
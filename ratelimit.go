@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiter throttles every query we send, wherever it goes. It's
+// unlimited until main configures it from DNS_RATE and friends.
+var limiter = newQueryLimiter(rate.Inf, 0, rate.Inf, 0)
+
+// queryLimiter is a global token bucket plus one per server, so a burst
+// can't all land on 8.8.8.8 even when the global budget allows it.
+type queryLimiter struct {
+	global *rate.Limiter
+
+	mu       sync.Mutex
+	perRate  rate.Limit
+	perBurst int
+	servers  map[string]*serverLimiter
+	swept    time.Time
+}
+
+type serverLimiter struct {
+	*rate.Limiter
+	used time.Time
+}
+
+// how long a server's bucket sits unused before it's dropped, at least.
+// Iterative mode and the nameserver and MX probes talk to thousands of
+// servers once or twice each, and a long-running checker shouldn't keep
+// a bucket for every one of them.
+const limiterIdle = 10 * time.Minute
+
+func newQueryLimiter(global rate.Limit, burst int, per rate.Limit, perBurst int) *queryLimiter {
+	return &queryLimiter{
+		global:   rate.NewLimiter(global, burst),
+		perRate:  per,
+		perBurst: perBurst,
+		servers:  make(map[string]*serverLimiter),
+		swept:    time.Now(),
+	}
+}
+
+func (l *queryLimiter) server(addr string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) > limiterIdle {
+		l.sweep(now)
+	}
+	s, ok := l.servers[addr]
+	if !ok {
+		s = &serverLimiter{Limiter: rate.NewLimiter(l.perRate, l.perBurst)}
+		l.servers[addr] = s
+	}
+	s.used = now
+	return s.Limiter
+}
+
+// sweep drops the buckets nobody has used in a while. One that's been
+// idle long enough to refill is no different from a new one, so the
+// server doesn't get a fresh burst it wouldn't have had anyway.
+func (l *queryLimiter) sweep(now time.Time) {
+	idle := limiterIdle
+	if l.perRate != rate.Inf && l.perRate > 0 {
+		idle = max(idle, time.Duration(float64(l.perBurst)/float64(l.perRate)*float64(time.Second)))
+	}
+	for addr, s := range l.servers {
+		if now.Sub(s.used) > idle {
+			delete(l.servers, addr)
+		}
+	}
+	l.swept = now
+}
+
+// wait blocks until a query to addr is allowed, or ctx is done.
+func (l *queryLimiter) wait(ctx context.Context, addr string) error {
+	if err := l.server(addr).Wait(ctx); err != nil {
+		return err
+	}
+	return l.global.Wait(ctx)
+}
+
+// parseRate reads "100/m"-style rates; a bare number is per second.
+func parseRate(s string) (rate.Limit, error) {
+	num, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad rate %q", s)
+	}
+	per := time.Second
+	if ok {
+		switch unit {
+		case "s":
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return 0, fmt.Errorf("bad rate unit %q", unit)
+		}
+	}
+	return rate.Limit(n / per.Seconds()), nil
+}
+
+// limiterFromEnv builds the limiter main installs.
+func limiterFromEnv() (*queryLimiter, error) {
+	var (
+		rates  [2]rate.Limit
+		bursts [2]int
+	)
+	for i, env := range [][4]string{
+		{"DNS_RATE", "100/m", "DNS_BURST", "10"},
+		{"DNS_SERVER_RATE", "60/m", "DNS_SERVER_BURST", "5"},
+	} {
+		r, err := parseRate(getEnv(env[0], env[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", env[0], err)
+		}
+		b, err := strconv.Atoi(getEnv(env[2], env[3]))
+		if err != nil || b < 1 {
+			return nil, fmt.Errorf("%s: bad burst %q", env[2], getEnv(env[2], env[3]))
+		}
+		rates[i], bursts[i] = r, b
+	}
+	return newQueryLimiter(rates[0], bursts[0], rates[1], bursts[1]), nil
+}
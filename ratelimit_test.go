@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
+)
+
+func TestParseRate(t *testing.T) {
+	for in, want := range map[string]rate.Limit{
+		"5":      5,
+		"100/m":  rate.Limit(100.0 / 60),
+		"3600/h": 1,
+		"2/s":    2,
+	} {
+		got, err := parseRate(in)
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: want %v got %v", in, want, got)
+		}
+	}
+	for _, bad := range []string{"", "fast", "0/m", "10/d", "-1"} {
+		if _, err := parseRate(bad); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+// TestLimiterThrottlesQueries drives real queries through exchange with
+// a tight per-server bucket.
+func TestLimiterThrottlesQueries(t *testing.T) {
+	z, _ := signedTree(t)
+	a, b := serveDNS(t, z), serveDNS(t, z)
+
+	old := limiter
+	limiter = newQueryLimiter(rate.Inf, 0, 20, 1) // one query per 50ms per server
+	t.Cleanup(func() { limiter = old })
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := query(ctx, a, "good.test", dns.TypeDS); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("3 queries to one server took only %v", d)
+	}
+
+	// a different server has its own bucket
+	start = time.Now()
+	if _, err := query(ctx, b, "good.test", dns.TypeDS); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 40*time.Millisecond {
+		t.Errorf("first query to a fresh server waited %v", d)
+	}
+
+	// and the global bucket caps everything
+	limiter = newQueryLimiter(20, 1, rate.Inf, 0)
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := query(ctx, a, "good.test", dns.TypeDS); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := query(ctx, b, "good.test", dns.TypeDS); err == nil {
+		t.Error("second query should have run out of time waiting")
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := newQueryLimiter(rate.Inf, 0, rate.Limit(1), 5)
+	l.server("192.0.2.1:53")
+	l.server("192.0.2.2:53")
+
+	now := time.Now()
+	l.servers["192.0.2.1:53"].used = now.Add(-time.Hour)
+	l.sweep(now)
+	if _, ok := l.servers["192.0.2.1:53"]; ok {
+		t.Error("idle server kept")
+	}
+	if _, ok := l.servers["192.0.2.2:53"]; !ok {
+		t.Error("busy server dropped")
+	}
+
+	// a bucket that hasn't refilled yet stays, however long it's been
+	slow := newQueryLimiter(rate.Inf, 0, rate.Every(time.Hour), 5)
+	slow.server("192.0.2.1:53")
+	slow.servers["192.0.2.1:53"].used = now.Add(-time.Hour)
+	slow.sweep(now)
+	if len(slow.servers) != 1 {
+		t.Error("slow server dropped before its bucket refilled")
+	}
+}
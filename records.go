@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type dsRecord struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     string
+	TTL        uint32
+}
+
+// dsRecords pulls the DS (or CDS) records out of an answer section, in a
+// stable order so two sets can be compared.
+func dsRecords(rrs []dns.RR) (ret []dsRecord) {
+	for _, rr := range rrs {
+		var ds *dns.DS
+		switch v := rr.(type) {
+		case *dns.DS:
+			ds = v
+		case *dns.CDS:
+			ds = &v.DS
+		default:
+			continue
+		}
+		ret = append(ret, dsRecord{
+			KeyTag:     ds.KeyTag,
+			Algorithm:  ds.Algorithm,
+			DigestType: ds.DigestType,
+			Digest:     strings.ToUpper(ds.Digest),
+			TTL:        ds.Hdr.Ttl,
+		})
+	}
+	slices.SortFunc(ret, func(a, b dsRecord) int {
+		return strings.Compare(a.key(), b.key())
+	})
+	return
+}
+
+func (r dsRecord) key() string {
+	return strings.Join([]string{
+		dns.AlgorithmToString[r.Algorithm],
+		dns.HashToString[r.DigestType],
+		r.Digest,
+	}, "/")
+}
+
+// sameDS compares two sorted sets, ignoring TTLs (which come out of a
+// resolver cache and count down).
+func sameDS(a, b []dsRecord) bool {
+	return slices.EqualFunc(a, b, func(x, y dsRecord) bool {
+		return x.KeyTag == y.KeyTag && x.key() == y.key()
+	})
+}
+
+// loadDS reads a check's records back out of table, which is ds_records
+// or cds_records.
+func loadDS(ctx context.Context, db *sql.DB, table string, checkID int) ([]dsRecord, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT key_tag, algorithm, digest_type, digest, ttl
+		FROM `+table+`
+		WHERE check_id = ?
+		ORDER BY id`,
+		checkID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []dsRecord
+	for rows.Next() {
+		var r dsRecord
+		if err := rows.Scan(
+			&r.KeyTag, &r.Algorithm, &r.DigestType, &r.Digest, &r.TTL,
+		); err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+func insertDS(ctx context.Context, tx *sql.Tx, table string, checkID int64, recs []dsRecord) error {
+	for _, r := range recs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO `+table+`(check_id, key_tag, algorithm,
+				digest_type, digest, ttl)
+			VALUES(?, ?, ?, ?, ?, ?)`,
+			checkID, r.KeyTag, r.Algorithm, r.DigestType, r.Digest, r.TTL,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dnskeyRecord struct {
+	KeyTag    uint16
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey string
+	TTL       uint32
+}
+
+// SEP reports whether the key has the secure entry point bit set, which
+// is how zones mark their KSKs.
+func (r dnskeyRecord) SEP() bool {
+	return r.Flags&dns.SEP != 0
+}
+
+// dnskeyRecords is dsRecords for DNSKEY (or CDNSKEY) records.
+func dnskeyRecords(rrs []dns.RR) (ret []dnskeyRecord) {
+	for _, rr := range rrs {
+		var k *dns.DNSKEY
+		switch v := rr.(type) {
+		case *dns.DNSKEY:
+			k = v
+		case *dns.CDNSKEY:
+			k = &v.DNSKEY
+		default:
+			continue
+		}
+		ret = append(ret, dnskeyRecord{
+			KeyTag:    k.KeyTag(),
+			Flags:     k.Flags,
+			Protocol:  k.Protocol,
+			Algorithm: k.Algorithm,
+			PublicKey: k.PublicKey,
+			TTL:       k.Hdr.Ttl,
+		})
+	}
+	slices.SortFunc(ret, func(a, b dnskeyRecord) int {
+		return strings.Compare(a.PublicKey, b.PublicKey)
+	})
+	return
+}
+
+// toDS computes the DS record a parent would publish for this key.
+func (r dnskeyRecord) toDS(zone string, digestType uint8) *dns.DS {
+	k := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(zone),
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+		},
+		Flags:     r.Flags,
+		Protocol:  r.Protocol,
+		Algorithm: r.Algorithm,
+		PublicKey: r.PublicKey,
+	}
+	return k.ToDS(digestType)
+}
+
+func sameDNSKEY(a, b []dnskeyRecord) bool {
+	return slices.EqualFunc(a, b, func(x, y dnskeyRecord) bool {
+		return x.Flags == y.Flags && x.Algorithm == y.Algorithm &&
+			x.PublicKey == y.PublicKey
+	})
+}
+
+// loadDNSKEY reads from dnskey_records or cdnskey_records.
+func loadDNSKEY(ctx context.Context, db *sql.DB, table string, checkID int) ([]dnskeyRecord, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT key_tag, flags, protocol, algorithm, public_key, ttl
+		FROM `+table+`
+		WHERE check_id = ?
+		ORDER BY id`,
+		checkID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []dnskeyRecord
+	for rows.Next() {
+		var r dnskeyRecord
+		if err := rows.Scan(
+			&r.KeyTag, &r.Flags, &r.Protocol, &r.Algorithm, &r.PublicKey, &r.TTL,
+		); err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+func insertDNSKEY(ctx context.Context, tx *sql.Tx, table string, checkID int64, recs []dnskeyRecord) error {
+	for _, r := range recs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO `+table+`(check_id, key_tag, flags,
+				protocol, algorithm, public_key, ttl)
+			VALUES(?, ?, ?, ?, ?, ?, ?)`,
+			checkID, r.KeyTag, r.Flags, r.Protocol, r.Algorithm,
+			r.PublicKey, r.TTL,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rrsigRecord struct {
+	TypeCovered uint16
+	KeyTag      uint16
+	Algorithm   uint8
+	Inception   time.Time
+	Expiration  time.Time
+}
+
+func rrsigRecords(sigs []*dns.RRSIG) (ret []rrsigRecord) {
+	for _, s := range sigs {
+		ret = append(ret, rrsigRecord{
+			TypeCovered: s.TypeCovered,
+			KeyTag:      s.KeyTag,
+			Algorithm:   s.Algorithm,
+			Inception:   time.Unix(int64(s.Inception), 0).UTC(),
+			Expiration:  time.Unix(int64(s.Expiration), 0).UTC(),
+		})
+	}
+	return
+}
+
+// sigExpiry is when the zone goes bogus if nobody re-signs it: each RRset
+// lives as long as its longest-lived signature, and the zone lives as
+// long as its shortest-lived RRset.
+func sigExpiry(sigs []rrsigRecord) time.Time {
+	byType := make(map[uint16]time.Time)
+	for _, s := range sigs {
+		if s.Expiration.After(byType[s.TypeCovered]) {
+			byType[s.TypeCovered] = s.Expiration
+		}
+	}
+
+	var ret time.Time
+	for _, exp := range byType {
+		if ret.IsZero() || exp.Before(ret) {
+			ret = exp
+		}
+	}
+	return ret
+}
+
+// saveSignatures replaces the domain's current set of apex signatures.
+func saveSignatures(ctx context.Context, db *sql.DB, domainID int, sigs []rrsigRecord) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM signatures WHERE domain_id = ?`, domainID,
+	); err != nil {
+		return err
+	}
+	for _, s := range sigs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO signatures(domain_id, type_covered, key_tag,
+				algorithm, inception, expiration)
+			VALUES(?, ?, ?, ?, ?, ?)`,
+			domainID, s.TypeCovered, s.KeyTag, s.Algorithm,
+			s.Inception.Unix(), s.Expiration.Unix(),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// resolverAnswer is one resolver's reply to one query. Rcode is -1 when
+// there was no reply at all.
+type resolverAnswer struct {
+	Resolver string
+	Rcode    int
+	AD       bool // the resolver validated the answer; needs DO on the query
+	TC       bool // the UDP answer was truncated, so we asked again over TCP
+	Answer   []string
+	RTT      time.Duration
+	Err      string
+}
+
+func (a resolverAnswer) RcodeName() string {
+	if a.Rcode < 0 {
+		return "no answer"
+	}
+	return dns.RcodeToString[a.Rcode]
+}
+
+func answerOf(server string, r *dns.Msg, rtt time.Duration, err error) resolverAnswer {
+	a := resolverAnswer{Resolver: server, Rcode: -1, RTT: rtt}
+	if err != nil {
+		a.Err = err.Error()
+	}
+	// a bogus SERVFAIL comes with both
+	if r == nil {
+		return a
+	}
+	a.Rcode = r.Rcode
+	a.AD = r.AuthenticatedData
+	a.TC = r.Truncated
+	for _, rr := range r.Answer {
+		a.Answer = append(a.Answer, rr.String())
+	}
+	return a
+}
+
+func loadAnswers(ctx context.Context, db *sql.DB, checkID int) ([]resolverAnswer, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT resolver, rcode, ad, tc, answer, rtt_ms, error
+		FROM resolver_answers
+		WHERE check_id = ?
+		ORDER BY id`,
+		checkID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []resolverAnswer
+	for rows.Next() {
+		var (
+			a      resolverAnswer
+			rcode  sql.NullInt64
+			answer string
+			ms     int64
+		)
+		if err := rows.Scan(
+			&a.Resolver, &rcode, &a.AD, &a.TC, &answer, &ms, &a.Err,
+		); err != nil {
+			return nil, err
+		}
+		a.Rcode = -1
+		if rcode.Valid {
+			a.Rcode = int(rcode.Int64)
+		}
+		if answer != "" {
+			a.Answer = strings.Split(answer, "\n")
+		}
+		a.RTT = time.Duration(ms) * time.Millisecond
+		ret = append(ret, a)
+	}
+	return ret, rows.Err()
+}
+
+func insertAnswers(ctx context.Context, tx *sql.Tx, checkID int64, answers []resolverAnswer) error {
+	for _, a := range answers {
+		var rcode sql.NullInt64
+		if a.Rcode >= 0 {
+			rcode = sql.NullInt64{Int64: int64(a.Rcode), Valid: true}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO resolver_answers(check_id, resolver, rcode, ad, tc,
+				answer, rtt_ms, error)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+			checkID, a.Resolver, rcode, a.AD, a.TC,
+			strings.Join(a.Answer, "\n"), a.RTT.Milliseconds(), a.Err,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
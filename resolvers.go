@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// a resolver that fails this many queries in a row leaves the rotation
+	evictAfter = 5
+	// and stays out this long before it gets another try
+	evictFor = 15 * time.Minute
+)
+
+var defaultResolvers = []string{
+	"8.8.8.8:53",
+	"1.1.1.1:53",
+	"9.9.9.9:53",
+}
+
+// pool is the set of resolvers probes go to; RESOLVERS replaces it.
+var pool = newResolverPool(defaultResolvers)
+
+type resolverStats struct {
+	Address       string
+	Queries       int64
+	Failures      int64 // includes timeouts
+	Timeouts      int64
+	Disagreements int64
+	DSLookups     int64         // answered DS lookups compared with another resolver's
+	RTT           time.Duration // summed over answered queries
+	Streak        int
+	DisabledUntil time.Time
+	LastError     string
+}
+
+func (s *resolverStats) healthy(now time.Time) bool {
+	return !now.Before(s.DisabledUntil)
+}
+
+type resolverPool struct {
+	mu      sync.Mutex
+	members []*resolverStats // duplicates are allowed, and share the first entry's stats
+}
+
+func newResolverPool(addrs []string) *resolverPool {
+	p := &resolverPool{}
+	for _, a := range addrs {
+		p.members = append(p.members, &resolverStats{Address: a})
+	}
+	return p
+}
+
+func (p *resolverPool) lookup(addr string) *resolverStats {
+	for _, m := range p.members {
+		if m.Address == addr {
+			return m
+		}
+	}
+	return nil
+}
+
+// pick returns k distinct members at random, preferring ones that are in
+// rotation. If too many are out, it falls back on evicted resolvers
+// rather than not answering at all.
+func (p *resolverPool) pick(k int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		now            = time.Now()
+		healthy, spare []string
+	)
+	for _, m := range p.members {
+		if m.healthy(now) {
+			healthy = append(healthy, m.Address)
+		} else {
+			spare = append(spare, m.Address)
+		}
+	}
+	ret := kOfN(k, healthy)
+	return append(ret, kOfN(k-len(ret), spare)...)
+}
+
+// one picks a single resolver.
+func (p *resolverPool) one() string {
+	return p.pick(1)[0]
+}
+
+// observe records the outcome of one query to addr. Servers that aren't
+// in the pool (authoritatives, in iterative mode) are ignored.
+func (p *resolverPool) observe(addr string, rtt time.Duration, r *dns.Msg, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m := p.lookup(addr)
+	if m == nil {
+		return
+	}
+	m.Queries++
+
+	// NXDOMAIN and friends are answers; these mean the resolver couldn't
+	// give us one. A bogus domain's SERVFAIL is the resolver doing its job.
+	ok := err == nil && r.Rcode != dns.RcodeServerFailure && r.Rcode != dns.RcodeRefused
+	if ok || classify(err) == failBogus {
+		m.RTT += rtt
+		m.Streak = 0
+		return
+	}
+
+	m.Failures++
+	m.Streak++
+	if err != nil {
+		m.LastError = err.Error()
+		if isTimeout(err) {
+			m.Timeouts++
+		}
+	} else {
+		m.LastError = dns.RcodeToString[r.Rcode]
+	}
+
+	// once over the threshold, every further failure (including the first
+	// one back after a break) sends it out again
+	if m.Streak >= evictAfter {
+		m.DisabledUntil = time.Now().Add(evictFor)
+		slog.Warn("resolver out of rotation", "resolver", addr,
+			"failures", m.Streak, "until", m.DisabledUntil, "err", m.LastError)
+	}
+}
+
+// compared notes that addrs answered a DS lookup each, and their answers
+// were compared. That's what a disagreement rate is out of.
+func (p *resolverPool) compared(addrs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, a := range addrs {
+		if m := p.lookup(a); m != nil {
+			m.DSLookups++
+		}
+	}
+}
+
+// disagree notes that addrs gave conflicting answers. With two opinions
+// there's no telling who's wrong, so everyone gets the blame.
+func (p *resolverPool) disagree(addrs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, a := range addrs {
+		if m := p.lookup(a); m != nil {
+			m.Disagreements++
+		}
+	}
+}
+
+func (p *resolverPool) addresses() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ret []string
+	for _, m := range p.members {
+		if p.lookup(m.Address) == m {
+			ret = append(ret, m.Address)
+		}
+	}
+	return ret
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &ne) && ne.Timeout())
+}
+
+// ask is exchange for resolvers in the pool: it keeps score. A SERVFAIL
+// that checkBogus pins on the domain comes back with a failBogus error.
+func ask(ctx context.Context, server string, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	start := time.Now()
+	r, err := exchange(ctx, server, m)
+	rtt := time.Since(start)
+	if err == nil && r.Rcode == dns.RcodeServerFailure && !m.CheckingDisabled {
+		r, err = checkBogus(ctx, server, m, r)
+	}
+	pool.observe(server, rtt, r, err)
+	return r, rtt, err
+}
+
+// load picks up where a previous run left off.
+func (p *resolverPool) load(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT address, queries, failures, timeouts, disagreements,
+		       ds_lookups, rtt_ms, streak, disabled_until, last_error
+		FROM resolvers`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for rows.Next() {
+		var (
+			s        resolverStats
+			rtt, dis int64
+		)
+		if err := rows.Scan(&s.Address, &s.Queries, &s.Failures, &s.Timeouts,
+			&s.Disagreements, &s.DSLookups, &rtt, &s.Streak, &dis,
+			&s.LastError); err != nil {
+			return err
+		}
+		s.RTT = time.Duration(rtt) * time.Millisecond
+		if dis > 0 {
+			s.DisabledUntil = time.Unix(dis, 0)
+		}
+		if m := p.lookup(s.Address); m != nil {
+			*m = s
+		}
+	}
+	return rows.Err()
+}
+
+// save writes the pool's counters back to the database.
+func (p *resolverPool) save(ctx context.Context, db *sql.DB) error {
+	p.mu.Lock()
+	var snap []resolverStats
+	for _, m := range p.members {
+		if p.lookup(m.Address) == m {
+			snap = append(snap, *m)
+		}
+	}
+	p.mu.Unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range snap {
+		var dis int64
+		if !s.DisabledUntil.IsZero() {
+			dis = s.DisabledUntil.Unix()
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO resolvers(address, queries, failures, timeouts,
+				disagreements, ds_lookups, rtt_ms, streak, disabled_until,
+				last_error, updated_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(address) DO UPDATE SET
+				queries = excluded.queries,
+				failures = excluded.failures,
+				timeouts = excluded.timeouts,
+				disagreements = excluded.disagreements,
+				ds_lookups = excluded.ds_lookups,
+				rtt_ms = excluded.rtt_ms,
+				streak = excluded.streak,
+				disabled_until = excluded.disabled_until,
+				last_error = excluded.last_error,
+				updated_at = excluded.updated_at`,
+			s.Address, s.Queries, s.Failures, s.Timeouts, s.Disagreements,
+			s.DSLookups, s.RTT.Milliseconds(), s.Streak, dis, s.LastError,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// usePool points probes at addrs for the rest of the test.
+func usePool(t *testing.T, addrs ...string) {
+	t.Helper()
+	old := pool
+	pool = newResolverPool(addrs)
+	t.Cleanup(func() { pool = old })
+}
+
+func TestResolverPoolEviction(t *testing.T) {
+	p := newResolverPool([]string{"a:53", "b:53", "c:53"})
+	ok := &dns.Msg{}
+	servfail := &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeServerFailure}}
+
+	for i := 0; i < evictAfter-1; i++ {
+		p.observe("a:53", time.Millisecond, nil, os.ErrDeadlineExceeded)
+	}
+	// a success clears the streak
+	p.observe("a:53", time.Millisecond, ok, nil)
+	for i := 0; i < evictAfter-1; i++ {
+		p.observe("a:53", time.Millisecond, servfail, nil)
+	}
+	for i := 0; i < 20; i++ {
+		if len(p.pick(3)) != 3 {
+			t.Fatal("short pick")
+		}
+	}
+
+	p.observe("a:53", time.Millisecond, nil, errors.New("connection refused"))
+	for i := 0; i < 20; i++ {
+		for _, r := range p.pick(2) {
+			if r == "a:53" {
+				t.Fatal("evicted resolver picked")
+			}
+		}
+	}
+	// but it's still there if nothing else is
+	if got := p.pick(3); len(got) != 3 || got[2] != "a:53" {
+		t.Fatalf("want a:53 as the fallback, got %v", got)
+	}
+
+	a := p.lookup("a:53")
+	if a.Queries != 2*evictAfter || a.Failures != 2*evictAfter-1 ||
+		a.Timeouts != evictAfter-1 {
+		t.Errorf("counters off: %+v", a)
+	}
+	// unknown servers (authoritatives) don't count
+	p.observe("192.0.2.1:53", time.Millisecond, ok, nil)
+	if len(p.addresses()) != 3 {
+		t.Errorf("pool grew: %v", p.addresses())
+	}
+}
+
+func TestResolverPoolPersist(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	p := newResolverPool([]string{"a:53", "b:53"})
+	p.observe("a:53", 30*time.Millisecond, &dns.Msg{}, nil)
+	p.observe("a:53", 10*time.Millisecond, &dns.Msg{}, nil)
+	for i := 0; i < evictAfter; i++ {
+		p.observe("b:53", time.Second, nil, os.ErrDeadlineExceeded)
+	}
+	// two DS lookups compared, one disagreement; the other queries don't
+	// come into it
+	p.compared("a:53", "b:53")
+	p.compared("a:53", "b:53")
+	p.disagree("a:53", "b:53")
+	p.observe("a:53", 20*time.Millisecond, &dns.Msg{}, nil)
+	p.observe("a:53", 20*time.Millisecond, &dns.Msg{}, nil)
+	if err := p.save(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	q := newResolverPool([]string{"a:53", "b:53"})
+	if err := q.load(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if got := q.pick(2); len(got) != 2 || got[0] != "a:53" {
+		t.Errorf("b should still be out after a restart, got %v", got)
+	}
+
+	usePool(t, "a:53", "b:53")
+	rows, err := resolverHealth(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("want 2 rows got %d", len(rows))
+	}
+	a, b := rows[0], rows[1]
+	if a.SuccessPct != 100 || a.DisagreePct != 50 || a.AvgRTT != 20*time.Millisecond {
+		t.Errorf("a: %+v", a)
+	}
+	if b.TimeoutPct != 100 || b.InRotation() {
+		t.Errorf("b: %+v", b)
+	}
+}
@@ -3,21 +3,52 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/rand/v2"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// scheduler keeps a pool of workers busy with domains, oldest check
+// first. How fast they go is up to the query limiter (DNS_RATE and
+// friends), which caps what all of them send put together; more workers
+// help for as long as checks spend their time waiting on slow servers
+// rather than on the limiter.
+type scheduler struct {
+	db       *sql.DB
+	interval time.Duration // least time between two domains; usually 0
+	retry    time.Duration // how long to wait when every domain is in flight
+	workers  int
+	maxRank  int
+	check    func(ctx context.Context, db *sql.DB, id int, name string) error
+
+	mu       sync.Mutex
+	inFlight map[int]bool // domains a worker is checking right now
+}
+
+type job struct {
+	id   int
+	name string
+}
+
 func startScheduler(ctx context.Context, db *sql.DB) error {
-	var (
-		count  int
-		durStr = getEnv("CHECK_INTERVAL", "") // see below
-		d      time.Duration
-	)
+	var count int
+
+	workers, err := workersFromEnv()
+	if err != nil {
+		return err
+	}
+	maxRank, err := strconv.Atoi(getEnv("CHECK_RANK", "1000"))
+	if err != nil || maxRank < 1 {
+		return fmt.Errorf("CHECK_RANK: want a positive number, got %q",
+			getEnv("CHECK_RANK", "1000"))
+	}
 
 	if err := db.QueryRow(
-		"SELECT COUNT(*) FROM domains",
+		"SELECT COUNT(*) FROM domains WHERE rank <= ?", maxRank,
 	).Scan(&count); err != nil {
 		return fmt.Errorf("count zones: %w", err)
 	}
@@ -25,51 +56,134 @@ func startScheduler(ctx context.Context, db *sql.DB) error {
 		return fmt.Errorf("no zones in db")
 	}
 
-	// by default, scale to zones over a whole day of probes
-	d = time.Duration(int64(24*time.Hour) / int64(count))
-
-	// probably don't want to set this
-	if durStr != "" {
-		if v, err := time.ParseDuration(durStr); err == nil {
-			d = v
+	// probably don't want to set this; the query limiter keeps us off
+	// 8.8.8.8's bad side without it
+	var d time.Duration
+	if v := getEnv("CHECK_INTERVAL", ""); v != "" {
+		if d, err = time.ParseDuration(v); err != nil || d < 0 {
+			return fmt.Errorf("CHECK_INTERVAL: want a duration, got %q", v)
 		}
 	}
 
-	// don't piss off 8.8.8.8
-	if d <= 0 {
-		d = time.Minute
+	s := &scheduler{
+		db:       db,
+		interval: d,
+		retry:    time.Second,
+		workers:  workers,
+		maxRank:  maxRank,
+		check:    checkDomain,
+		inFlight: make(map[int]bool),
 	}
-
-	go schedulerLoop(ctx, db, d)
+	slog.Info("scheduler", "interval", d, "workers", workers, "domains", count)
+	go s.run(ctx)
 	return nil
 }
 
-func schedulerLoop(ctx context.Context, db *sql.DB, interval time.Duration) {
-	t := time.NewTicker(interval)
+func workersFromEnv() (int, error) {
+	v := getEnv("CONCURRENT_WORKERS", "1")
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("CONCURRENT_WORKERS: want a positive number, got %q", v)
+	}
+	return n, nil
+}
 
+func (s *scheduler) run(ctx context.Context) {
+	var (
+		jobs = make(chan job)
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx, jobs)
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		t := time.NewTicker(s.interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	// the next domain is picked as soon as a worker is free to take it
 	for {
-		select {
-		case <-ctx.Done():
-			t.Stop()
-			return
-		case <-t.C:
-			id, name, err := nextDomain(ctx, db)
-			if err != nil {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+
+		id, name, err := s.next(ctx)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
 				slog.Error("next", "err", err)
-				continue
 			}
-			if err := checkDomain(ctx, db, id, name); err != nil {
-				slog.Error("check", "err", err, "domain", name)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.retry):
 			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			s.done(id)
+			return
+		case jobs <- job{id, name}:
 		}
 	}
 }
 
-func nextDomain(ctx context.Context, db *sql.DB) (int, string, error) {
+func (s *scheduler) work(ctx context.Context, jobs <-chan job) {
+	for j := range jobs {
+		if err := s.check(ctx, s.db, j.id, j.name); err != nil {
+			slog.Error("check", "err", err, "domain", j.name)
+		}
+		if err := pool.save(ctx, s.db); err != nil {
+			slog.Error("save resolver stats", "err", err)
+		}
+		s.done(j.id)
+	}
+}
+
+// next picks a domain nobody is working on and marks it in flight.
+func (s *scheduler) next(ctx context.Context) (int, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	skip := make([]int, 0, len(s.inFlight))
+	for id := range s.inFlight {
+		skip = append(skip, id)
+	}
+	id, name, err := nextDomain(ctx, s.db, s.maxRank, skip)
+	if err != nil {
+		return 0, "", err
+	}
+	s.inFlight[id] = true
+	return id, name, nil
+}
+
+func (s *scheduler) done(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, id)
+}
+
+// nextDomain picks one of the least recently checked domains up to
+// maxRank, leaving out the ones in skip.
+func nextDomain(ctx context.Context, db *sql.DB, maxRank int, skip []int) (int, string, error) {
 	// subquery c: for each domain in dns_checks, get the most recent checked_at timestamp
 	// join w/ domains on domain_id, left join to incl. zones w/ no checks
-	// take 5, sorted by tranco rank, so we have some jitter and don't get stuck
-	// on wacky corner cases
+	// take 5 (plus however many we have to skip), sorted by tranco rank, so
+	// we have some jitter and don't get stuck on wacky corner cases
 	rows, err := db.QueryContext(ctx, `
                SELECT d.id, d.name
                FROM domains d
@@ -79,15 +193,22 @@ func nextDomain(ctx context.Context, db *sql.DB) (int, string, error) {
                FROM dns_checks dc
                GROUP BY dc.domain_id
        ) c ON d.id = c.domain_id
-       WHERE d.rank <= 1000
+       WHERE d.rank <= ?
        ORDER BY COALESCE(last_check, '1970-01-01') ASC,
        d.rank ASC
-       LIMIT 5`)
+       LIMIT ?`,
+		maxRank, 5+len(skip),
+	)
 	if err != nil {
 		return 0, "", fmt.Errorf("query next zones: %w", err)
 	}
 	defer rows.Close()
 
+	skipped := make(map[int]bool, len(skip))
+	for _, id := range skip {
+		skipped[id] = true
+	}
+
 	var (
 		ids   []int
 		names []string
@@ -100,6 +221,9 @@ func nextDomain(ctx context.Context, db *sql.DB) (int, string, error) {
 		if err := rows.Scan(&id, &name); err != nil {
 			return 0, "", err
 		}
+		if skipped[id] || len(ids) == 5 {
+			continue
+		}
 		ids = append(ids, id)
 		names = append(names, name)
 	}
@@ -114,70 +238,3 @@ func nextDomain(ctx context.Context, db *sql.DB) (int, string, error) {
 	i := rand.IntN(len(ids))
 	return ids[i], names[i], nil
 }
-
-func checkDomain(ctx context.Context, db *sql.DB, id int, name string) error {
-	slog.Info("checking", "domain", name)
-
-	var (
-		lastID  int
-		lastHas sql.NullBool
-		lastErr sql.NullString
-	)
-	err := db.QueryRowContext(ctx, `
-			SELECT id, has_dnssec, error
-        	FROM dns_checks
-            WHERE domain_id = ?
-            ORDER BY checked_at DESC
-            LIMIT 1`,
-		id,
-	).Scan(&lastID, &lastHas, &lastErr)
-	if err != nil && err != sql.ErrNoRows {
-		return err
-	}
-
-	records, err := lookupDS(ctx, name)
-	var (
-		has    bool
-		errStr string
-	)
-	if err != nil {
-		errStr = err.Error()
-		if lastHas.Valid {
-			has = lastHas.Bool
-		}
-	} else {
-		has = len(records) > 0
-	}
-
-	var sameErr bool
-	if lastErr.Valid {
-		sameErr = lastErr.String == errStr
-	} else {
-		sameErr = errStr == ""
-	}
-
-	sameHas := lastHas.Valid && lastHas.Bool == has
-	if sameHas && sameErr {
-		// TODO: missing txn
-		_, err = db.ExecContext(ctx, `
-			UPDATE dns_checks
-        	SET checked_at = CURRENT_TIMESTAMP
-           	WHERE id = ?`,
-			lastID,
-		)
-		return err
-	}
-
-	// TODO: missing txn
-	_, err = db.ExecContext(ctx, `
-			INSERT INTO dns_checks(domain_id, has_dnssec, error)
-            VALUES(?, ?, ?)`,
-		id, has, errStr,
-	)
-	if err != nil {
-		return err
-	}
-
-	_, err = db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`)
-	return err
-}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSchedulerWorkers runs several workers against a handful of domains
+// and makes sure none is ever checked by two workers at once.
+func TestSchedulerWorkers(t *testing.T) {
+	db := testDB(t)
+	names := seedDomains(t, db, 6)
+
+	var (
+		mu      sync.Mutex
+		busy    = make(map[int]bool)
+		checked = make(map[string]int)
+		peak    int
+	)
+	check := func(ctx context.Context, db *sql.DB, id int, name string) error {
+		mu.Lock()
+		if busy[id] {
+			mu.Unlock()
+			t.Errorf("%s checked twice at once", name)
+			return nil
+		}
+		busy[id] = true
+		peak = max(peak, len(busy))
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		// insertCheck would t.Fatal off the test goroutine
+		if _, err := db.Exec(
+			"INSERT INTO dns_checks(domain_id, checked_at) VALUES(?, ?)",
+			id, time.Now().UTC().Format(time.RFC3339Nano),
+		); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		delete(busy, id)
+		checked[name]++
+		mu.Unlock()
+		return nil
+	}
+
+	s := &scheduler{
+		db:       db,
+		retry:    time.Millisecond,
+		workers:  4,
+		maxRank:  1000,
+		check:    check,
+		inFlight: make(map[int]bool),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	s.run(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range names {
+		if checked[name] == 0 {
+			t.Errorf("%s never checked", name)
+		}
+	}
+	if peak < 2 {
+		t.Errorf("workers never overlapped (peak %d)", peak)
+	}
+	if len(s.inFlight) != 0 {
+		t.Errorf("left in flight: %v", s.inFlight)
+	}
+}
+
+// TestSchedulerThroughput makes sure more workers get through a pass
+// sooner when checks spend their time waiting, rather than being held to
+// a fixed schedule.
+func TestSchedulerThroughput(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	pass := func(workers int) time.Duration {
+		db := testDB(t)
+		names := seedDomains(t, db, 8)
+
+		var (
+			mu      sync.Mutex
+			checked = make(map[string]bool)
+		)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		check := func(ctx context.Context, db *sql.DB, id int, name string) error {
+			// a slow server, not the limiter
+			time.Sleep(delay)
+			if _, err := db.Exec(
+				"INSERT INTO dns_checks(domain_id, checked_at) VALUES(?, ?)",
+				id, time.Now().UTC().Format(time.RFC3339Nano),
+			); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			checked[name] = true
+			if len(checked) == len(names) {
+				cancel()
+			}
+			return nil
+		}
+
+		s := &scheduler{
+			db:       db,
+			retry:    time.Millisecond,
+			workers:  workers,
+			maxRank:  1000,
+			check:    check,
+			inFlight: make(map[int]bool),
+		}
+		start := time.Now()
+		s.run(ctx)
+		if len(checked) != len(names) {
+			t.Fatalf("%d workers: checked %d of %d", workers, len(checked), len(names))
+		}
+		return time.Since(start)
+	}
+
+	// one worker can't do better than 8 delays back to back; four should,
+	// whatever the database costs
+	one, four := pass(1), pass(4)
+	if four >= one || four >= 8*delay {
+		t.Errorf("4 workers took %v, 1 took %v", four, one)
+	}
+}
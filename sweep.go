@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusLabel boils a check down to the one word the index would show.
+func statusLabel(res *checkResult) string {
+	if res == nil {
+		return "unchecked"
+	}
+	switch res.Status() {
+	case statusUnknown:
+		if res.Err == "" {
+			return "unknown"
+		}
+		return "error"
+	case statusBogus:
+		return "bogus"
+	case statusSecure:
+		return "enabled"
+	}
+	if res.HasDNSKEY {
+		return "signed, no DS"
+	}
+	return "disabled"
+}
+
+// openSweep resumes the unfinished sweep up to maxRank, or starts a new
+// one, recording where every domain stands beforehand.
+func openSweep(ctx context.Context, db *sql.DB, maxRank int) (int, bool, error) {
+	var id int
+	err := db.QueryRowContext(ctx, `
+		SELECT id FROM sweeps
+		WHERE max_rank = ? AND finished_at IS NULL
+		ORDER BY id DESC LIMIT 1`,
+		maxRank,
+	).Scan(&id)
+	if err == nil {
+		return id, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT id FROM domains WHERE rank <= ? ORDER BY rank`, maxRank)
+	if err != nil {
+		return 0, false, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, false, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, false, err
+	}
+
+	before := make([]string, len(ids))
+	for i, domainID := range ids {
+		_, last, err := lastCheck(ctx, db, domainID)
+		if err != nil {
+			return 0, false, err
+		}
+		before[i] = statusLabel(last)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	r, err := tx.ExecContext(ctx, `INSERT INTO sweeps(max_rank) VALUES(?)`, maxRank)
+	if err != nil {
+		return 0, false, err
+	}
+	sweepID, err := r.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+	for i, domainID := range ids {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO sweep_domains(sweep_id, domain_id, before)
+			VALUES(?, ?, ?)`,
+			sweepID, domainID, before[i],
+		); err != nil {
+			return 0, false, err
+		}
+	}
+	return int(sweepID), false, tx.Commit()
+}
+
+// runSweep checks every domain up to maxRank once, printing progress to
+// w as it goes and a summary of what changed at the end. If ctx is
+// cancelled it stops handing out domains, lets the ones in flight finish,
+// and leaves the sweep open to be resumed by the next run.
+func runSweep(ctx context.Context, db *sql.DB, maxRank, workers int, w io.Writer) error {
+	sweepID, resumed, err := openSweep(ctx, db, maxRank)
+	if err != nil {
+		return fmt.Errorf("open sweep: %w", err)
+	}
+
+	var total, done int
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(after) FROM sweep_domains WHERE sweep_id = ?`,
+		sweepID,
+	).Scan(&total, &done); err != nil {
+		return err
+	}
+	if resumed {
+		fmt.Fprintf(w, "resuming sweep %d: %d of %d already checked\n", sweepID, done, total)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.id, d.name
+		FROM sweep_domains s
+		JOIN domains d ON d.id = s.domain_id
+		WHERE s.sweep_id = ? AND s.after IS NULL
+		ORDER BY d.rank`,
+		sweepID,
+	)
+	if err != nil {
+		return err
+	}
+	var todo []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.id, &j.name); err != nil {
+			rows.Close()
+			return err
+		}
+		todo = append(todo, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var (
+		jobs  = make(chan job)
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  int
+		start = time.Now()
+		first = done
+	)
+	// checks run on their own context: cancelling one halfway would only
+	// record a bogus error for it
+	work := context.WithoutCancel(ctx)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				label := "error"
+				if err := checkDomain(work, db, j.id, j.name); err == nil {
+					if _, last, err := lastCheck(work, db, j.id); err == nil {
+						label = statusLabel(last)
+					}
+				}
+				_, err := db.ExecContext(work, `
+					UPDATE sweep_domains SET after = ?
+					WHERE sweep_id = ? AND domain_id = ?`,
+					label, sweepID, j.id,
+				)
+
+				mu.Lock()
+				done++
+				if label == "error" || err != nil {
+					errs++
+				}
+				fmt.Fprintf(w, "\r%d/%d checked, %d errors, ETA %s   ",
+					done, total, errs, sweepETA(start, done-first, total-done))
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, j := range todo {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- j:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	fmt.Fprintln(w)
+
+	if err := pool.save(work, db); err != nil {
+		return fmt.Errorf("save resolver stats: %w", err)
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintf(w, "interrupted at %d/%d; run again to resume\n", done, total)
+		return ctx.Err()
+	}
+
+	if _, err := db.ExecContext(work,
+		`UPDATE sweeps SET finished_at = CURRENT_TIMESTAMP WHERE id = ?`, sweepID,
+	); err != nil {
+		return err
+	}
+	return sweepSummary(work, db, sweepID, w)
+}
+
+func sweepETA(start time.Time, done, left int) string {
+	if done == 0 {
+		return "?"
+	}
+	per := time.Since(start) / time.Duration(done)
+	return (per * time.Duration(left)).Round(time.Second).String()
+}
+
+// sweepSummary prints how many domains ended up in each state, and every
+// domain whose state changed over the sweep.
+func sweepSummary(ctx context.Context, db *sql.DB, sweepID int, w io.Writer) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.name, s.before, s.after
+		FROM sweep_domains s
+		JOIN domains d ON d.id = s.domain_id
+		WHERE s.sweep_id = ?
+		ORDER BY d.rank`,
+		sweepID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		counts  = make(map[string]int)
+		changes []string
+	)
+	for rows.Next() {
+		var name, before, after string
+		if err := rows.Scan(&name, &before, &after); err != nil {
+			return err
+		}
+		counts[after]++
+		if before != after {
+			changes = append(changes, fmt.Sprintf("  %s: %s -> %s", name, before, after))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	labels := make([]string, 0, len(counts))
+	for l := range counts {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%-14s %d\n", l, counts[l])
+	}
+	fmt.Fprintf(w, "%d changed\n", len(changes))
+	for _, c := range changes {
+		fmt.Fprintln(w, c)
+	}
+	return nil
+}
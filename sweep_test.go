@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	z, _ := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	db := testDB(t)
+	ctx := context.Background()
+	insertDomain(t, db, "good.test", 1)
+	insertDomain(t, db, "nods.test", 2)
+	plain := insertDomain(t, db, "plain.test", 3)
+	insertDomain(t, db, "other.test", 4) // past the sweep
+	// in the format CURRENT_TIMESTAMP uses, so it sorts before the sweep's
+	if _, err := db.Exec(`
+		INSERT INTO dns_checks(domain_id, checked_at, has_dnssec, error, status)
+		SELECT id, ?, 0, '', 'insecure' FROM domains WHERE name = 'good.test'`,
+		time.Now().Add(-time.Hour).UTC().Format(time.DateTime),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// an earlier run got interrupted after plain.test
+	sweepID, resumed, err := openSweep(ctx, db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed {
+		t.Fatal("nothing to resume yet")
+	}
+	if _, err := db.Exec(`
+		UPDATE sweep_domains SET after = 'disabled'
+		WHERE sweep_id = ? AND domain_id = ?`,
+		sweepID, plain,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runSweep(ctx, db, 3, 2, &out); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"resuming sweep",
+		"1 of 3 already checked",
+		"3/3 checked, 0 errors",
+		"good.test: disabled -> enabled",
+		"nods.test: unchecked -> signed, no DS",
+		"plain.test: unchecked -> disabled",
+		"3 changed",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+
+	// the resumed domain wasn't checked again, and nothing past rank 3 was
+	var n int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM dns_checks c
+		JOIN domains d ON d.id = c.domain_id
+		WHERE d.name IN ('plain.test', 'other.test')`,
+	).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("want no checks for plain/other, got %d", n)
+	}
+
+	// and the next sweep starts over
+	if _, resumed, err := openSweep(ctx, db, 3); err != nil || resumed {
+		t.Errorf("finished sweep resumed (%v)", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// "TLD" here is really the public suffix a domain is registered under,
+// co.uk rather than uk for bbc.co.uk, since that's the zone that has to
+// be signed to take the domain's DS. A suffix that isn't a zone, like
+// co.jp, is as signed as the zone it's in.
+
+// TLDs change signing status about once a decade, so each one is looked
+// at no more often than this however many of its domains get checked.
+const tldInterval = 24 * time.Hour
+
+// tldRow is what we know about one TLD, and how the tracked domains
+// under it are doing.
+type tldRow struct {
+	Name      string
+	Probed    bool // false until a probe of the TLD has succeeded
+	HasDS     bool // its parent vouches for it
+	HasDNSKEY bool
+	Err       string // from the latest probe
+	CheckedAt time.Time
+
+	Domains  int
+	Secure   int
+	Insecure int
+	Bogus    int
+	Unknown  int // never checked, or the last check failed
+	Pct      float64
+}
+
+// Signed reports whether a domain under the TLD could get a chain of
+// trust if it asked for one.
+func (t tldRow) Signed() bool {
+	return t.Probed && t.HasDS
+}
+
+// probeTLD asks whether the parent of the zone tld is in has a DS for it,
+// and whether that zone publishes keys of its own. Plenty of suffixes
+// (co.jp, com.br) are just names in the TLD's zone, not zones, and have
+// neither.
+func probeTLD(ctx context.Context, tld string) (hasDS, hasDNSKEY bool, err error) {
+	zone, err := zoneOf(ctx, tld)
+	if err != nil {
+		return false, false, fmt.Errorf("soa: %w", err)
+	}
+	ds, _, err := lookupDS(ctx, zone)
+	if err != nil {
+		return false, false, fmt.Errorf("ds: %w", err)
+	}
+	keys, _, err := lookupApex(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return false, false, fmt.Errorf("dnskey: %w", err)
+	}
+	return len(ds) > 0, len(keys) > 0, nil
+}
+
+// zoneOf finds the zone name is in: name itself if it has an SOA, or else
+// the zone whose SOA comes back with the negative answer (RFC 2308 3).
+func zoneOf(ctx context.Context, name string) (string, error) {
+	name = dns.Fqdn(name)
+	r, err := query(ctx, pool.one(), name, dns.TypeSOA)
+	if err != nil {
+		return "", err
+	}
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return "", rcodeError("soa", r.Rcode)
+	}
+	for _, rr := range append(r.Answer, r.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, name) {
+			return soa.Hdr.Name, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no SOA", name)
+}
+
+// refreshTLD probes the TLD a domain falls under unless somebody has
+// done so within tldInterval. A failed probe is recorded, but keeps
+// whatever the last good one found.
+func refreshTLD(ctx context.Context, db *sql.DB, domainID int) error {
+	var name, suffix string
+	if err := db.QueryRowContext(ctx,
+		`SELECT name, COALESCE(public_suffix, '') FROM domains WHERE id = ?`,
+		domainID,
+	).Scan(&name, &suffix); err != nil {
+		return err
+	}
+	_, tld := domainParts(name, suffix)
+	if tld == "" {
+		return nil
+	}
+
+	var fresh bool
+	err := db.QueryRowContext(ctx,
+		`SELECT checked_at > datetime('now', ?) FROM tlds WHERE name = ?`,
+		fmt.Sprintf("-%d seconds", int(tldInterval.Seconds())), tld,
+	).Scan(&fresh)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if fresh {
+		return nil
+	}
+
+	hasDS, hasDNSKEY, err := probeTLD(ctx, tld)
+	if err != nil {
+		slog.Warn("tld", "tld", tld, "err", err)
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO tlds(name, error) VALUES(?, ?)
+			ON CONFLICT(name) DO UPDATE SET
+				error = excluded.error,
+				checked_at = CURRENT_TIMESTAMP`,
+			tld, err.Error(),
+		)
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO tlds(name, has_ds, has_dnskey) VALUES(?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			has_ds = excluded.has_ds,
+			has_dnskey = excluded.has_dnskey,
+			error = '',
+			checked_at = CURRENT_TIMESTAMP`,
+		tld, hasDS, hasDNSKEY,
+	)
+	return err
+}
+
+// loadTLD returns what we know about one TLD, or nil if it's never been
+// probed.
+func loadTLD(ctx context.Context, db *sql.DB, tld string) (*tldRow, error) {
+	t := tldRow{Name: tld}
+	var hasDS, hasDNSKEY sql.NullBool
+	err := db.QueryRowContext(ctx,
+		`SELECT has_ds, has_dnskey, error, checked_at FROM tlds WHERE name = ?`,
+		tld,
+	).Scan(&hasDS, &hasDNSKEY, &t.Err, &t.CheckedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Probed = hasDS.Valid
+	t.HasDS = hasDS.Bool
+	t.HasDNSKEY = hasDNSKEY.Bool
+	return &t, nil
+}
+
+// tldSummary splits the top-N domains that don't sign by whether their
+// TLD gave them the option.
+type tldSummary struct {
+	Cant     int // insecure under an unsigned TLD
+	Chose    int // insecure under a signed one
+	Unprobed int // insecure under a TLD we haven't managed to probe
+}
+
+// tldStats is dnssecRatio broken down by TLD, most domains first. The
+// grouping happens here so domains nobody has split yet can fall back on
+// their last label.
+func tldStats(ctx context.Context, db *sql.DB, limit int) ([]tldRow, tldSummary, error) {
+	var sum tldSummary
+
+	probed := make(map[string]tldRow)
+	rows, err := db.QueryContext(ctx, `
+		SELECT name, has_ds, has_dnskey, error, checked_at FROM tlds`)
+	if err != nil {
+		return nil, sum, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			t                tldRow
+			hasDS, hasDNSKEY sql.NullBool
+		)
+		if err := rows.Scan(
+			&t.Name, &hasDS, &hasDNSKEY, &t.Err, &t.CheckedAt,
+		); err != nil {
+			return nil, sum, err
+		}
+		t.Probed = hasDS.Valid
+		t.HasDS = hasDS.Bool
+		t.HasDNSKEY = hasDNSKEY.Bool
+		probed[t.Name] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sum, err
+	}
+	rows.Close()
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT d.name, d.public_suffix, c.status
+		FROM domains d
+		LEFT JOIN dns_checks c ON c.id = (
+			SELECT id FROM dns_checks dc
+			WHERE dc.domain_id = d.id
+			ORDER BY dc.checked_at DESC LIMIT 1
+		)
+		WHERE d.rank <= ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, sum, err
+	}
+	defer rows.Close()
+
+	byTLD := make(map[string]*tldRow)
+	for rows.Next() {
+		var (
+			name           string
+			suffix, status sql.NullString
+		)
+		if err := rows.Scan(&name, &suffix, &status); err != nil {
+			return nil, sum, err
+		}
+		_, tld := domainParts(name, suffix.String)
+		t, ok := byTLD[tld]
+		if !ok {
+			t = &tldRow{Name: tld}
+			if p, ok := probed[tld]; ok {
+				*t = p
+			}
+			byTLD[tld] = t
+		}
+		t.Domains++
+		switch status.String {
+		case statusSecure:
+			t.Secure++
+		case statusBogus:
+			t.Bogus++
+		case statusInsecure:
+			t.Insecure++
+			switch {
+			case !t.Probed:
+				sum.Unprobed++
+			case t.HasDS:
+				sum.Chose++
+			default:
+				sum.Cant++
+			}
+		default:
+			t.Unknown++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sum, err
+	}
+
+	ret := make([]tldRow, 0, len(byTLD))
+	for _, t := range byTLD {
+		if known := t.Secure + t.Insecure + t.Bogus; known > 0 {
+			t.Pct = 100 * float64(t.Secure) / float64(known)
+		}
+		ret = append(ret, *t)
+	}
+	slices.SortFunc(ret, func(a, b tldRow) int {
+		return cmp.Or(cmp.Compare(b.Domains, a.Domains), cmp.Compare(a.Name, b.Name))
+	})
+	return ret, sum, nil
+}
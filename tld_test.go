@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRefreshTLD(t *testing.T) {
+	z, _ := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	db := testDB(t)
+	id := insertDomain(t, db, "good.test", 1)
+	ctx := context.Background()
+	if err := checkDomain(ctx, db, id, "good.test"); err != nil {
+		t.Fatal(err)
+	}
+	tld, err := loadTLD(ctx, db, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tld == nil || !tld.Signed() || !tld.HasDNSKEY || tld.Err != "" {
+		t.Fatalf("want test. signed, got %+v", tld)
+	}
+
+	// the root drops it; nobody notices until the probe goes stale
+	z.mu.Lock()
+	delete(z.rrs, zoneKey("test.", dns.TypeDS))
+	z.mu.Unlock()
+	if err := refreshTLD(ctx, db, id); err != nil {
+		t.Fatal(err)
+	}
+	if tld, _ = loadTLD(ctx, db, "test"); !tld.Signed() {
+		t.Fatal("fresh probe was redone")
+	}
+
+	if _, err := db.Exec(`UPDATE tlds SET checked_at = ?`,
+		time.Now().Add(-2*tldInterval).UTC().Format(time.DateTime),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := refreshTLD(ctx, db, id); err != nil {
+		t.Fatal(err)
+	}
+	if tld, _ = loadTLD(ctx, db, "test"); tld.Signed() || !tld.Probed {
+		t.Fatalf("want test. unsigned, got %+v", tld)
+	}
+}
+
+// TestProbeTLDNotAZone makes sure a suffix that's just a name in its TLD
+// is judged by the TLD's zone.
+func TestProbeTLDNotAZone(t *testing.T) {
+	z, _ := signedTree(t)
+	addr := serveDNS(t, z)
+	usePool(t, addr, addr)
+
+	hasDS, hasDNSKEY, err := probeTLD(context.Background(), "co.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasDS || !hasDNSKEY {
+		t.Errorf("want co.test signed like test., got ds %v dnskey %v", hasDS, hasDNSKEY)
+	}
+}
+
+func TestTLDStats(t *testing.T) {
+	db := testDB(t)
+	now := time.Now()
+	for i, name := range []string{
+		"a.signed", "b.signed", "c.flat", "d.flat", "e.dunno",
+	} {
+		insertDomain(t, db, name, i+1)
+	}
+	insertCheck(t, db, "a.signed", now, true)
+	insertCheck(t, db, "b.signed", now, false)
+	insertCheck(t, db, "c.flat", now, false)
+	insertCheck(t, db, "e.dunno", now, false)
+	if _, err := db.Exec(`
+		INSERT INTO tlds(name, has_ds, has_dnskey)
+		VALUES('signed', 1, 1), ('flat', 0, 0)`,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	list, sum, err := tldStats(context.Background(), db, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (tldSummary{Cant: 1, Chose: 1, Unprobed: 1}); sum != want {
+		t.Errorf("want summary %+v, got %+v", want, sum)
+	}
+
+	type row struct {
+		Name                      string
+		Signed                    bool
+		Domains, Secure, Insecure int
+		Unknown                   int
+		Pct                       float64
+	}
+	var got []row
+	for _, r := range list {
+		got = append(got, row{r.Name, r.Signed(), r.Domains, r.Secure,
+			r.Insecure, r.Unknown, r.Pct})
+	}
+	want := []row{
+		{"flat", false, 2, 0, 1, 1, 0},
+		{"signed", true, 2, 1, 1, 0, 50},
+		{"dunno", false, 1, 0, 1, 0, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v\ngot  %+v", want, got)
+	}
+}
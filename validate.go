@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// validation verdicts, RFC 4033 section 5 more or less
+const (
+	verdictSecure        = "secure"
+	verdictInsecure      = "insecure"
+	verdictBogus         = "bogus"
+	verdictIndeterminate = "indeterminate"
+)
+
+// rootAnchors are the IANA root KSKs (KSK-2017 and KSK-2024). Tests swap
+// these out for their own.
+var rootAnchors = mustDS(
+	". 172800 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 172800 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+)
+
+func mustDS(lines ...string) (ret []*dns.DS) {
+	for _, l := range lines {
+		rr, err := dns.NewRR(l)
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, rr.(*dns.DS))
+	}
+	return
+}
+
+// we can only check what miekg/dns can verify; anything else is treated
+// as insecure, per RFC 4035 5.2
+var supportedAlgorithms = map[uint8]bool{
+	dns.RSASHA1:          true,
+	dns.RSASHA1NSEC3SHA1: true,
+	dns.RSASHA256:        true,
+	dns.RSASHA512:        true,
+	dns.ECDSAP256SHA256:  true,
+	dns.ECDSAP384SHA384:  true,
+	dns.ED25519:          true,
+}
+
+var supportedDigests = map[uint8]bool{
+	dns.SHA1:   true,
+	dns.SHA256: true,
+	dns.SHA384: true,
+}
+
+type validation struct {
+	Verdict string
+	Reason  string
+	Path    []string // each step of the walk, for -check; not stored
+
+	err error // what stopped an indeterminate walk
+}
+
+// errBogus marks a failure that proves the chain is broken, as opposed to
+// one that just means we couldn't get an answer.
+var errBogus = errors.New("bogus")
+
+func bogusf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errBogus, fmt.Sprintf(format, args...))
+}
+
+// validateDomain walks the chain of trust from the root down to domain,
+// fetching DNSKEY and DS sets (with signatures) from server and checking
+// each link. It doesn't check NSEC/NSEC3 proofs for missing DS records;
+// a signed "no DS" answer from a secure parent counts as insecure.
+func validateDomain(ctx context.Context, server, domain string) validation {
+	var path []string
+	v, err := walkChain(ctx, server, domain, &path)
+	switch {
+	case errors.Is(err, errBogus), classify(err) == failBogus:
+		v = validation{Verdict: verdictBogus, Reason: err.Error()}
+	case err != nil:
+		v = validation{Verdict: verdictIndeterminate, Reason: err.Error(), err: err}
+	}
+	v.Path = path
+	return v
+}
+
+// validateRetry is validateDomain with withRetry's patience, and a fresh
+// resolver each try: a walk that times out partway says nothing about the
+// domain, and shouldn't make it unknown.
+func validateRetry(ctx context.Context, domain string) validation {
+	var v validation
+	withRetry(ctx, "validation "+domain, func() error {
+		v = validateDomain(ctx, pool.one(), domain)
+		return v.err
+	})
+	return v
+}
+
+func walkChain(ctx context.Context, server, domain string, path *[]string) (validation, error) {
+	step := func(format string, args ...any) {
+		*path = append(*path, fmt.Sprintf(format, args...))
+	}
+
+	zone := "."
+	keys, err := fetchKeys(ctx, server, zone, rootAnchors)
+	if err != nil {
+		return validation{}, err
+	}
+	step(". DNSKEY: %d keys, signed by a root trust anchor", len(keys))
+
+	labels := dns.SplitDomainName(domain)
+	for i := len(labels) - 1; i >= 0; i-- {
+		name := dns.Fqdn(strings.Join(labels[i:], "."))
+		last := i == 0
+
+		r, err := queryCD(ctx, server, name, dns.TypeDS)
+		if err != nil {
+			return validation{}, err
+		}
+		if r.Rcode == dns.RcodeNameError {
+			return validation{}, fmt.Errorf("%s: NXDOMAIN", name)
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			return validation{}, rcodeError(name+" DS", r.Rcode)
+		}
+
+		set, sigs := rrsetOf(r.Answer, name, dns.TypeDS)
+		if len(set) == 0 {
+			step("%s DS: none in %s", name, zone)
+			if !last {
+				// not every label is a zone cut (think co.uk vs. a
+				// plain subdomain); if there are no keys here either,
+				// we're still inside the parent zone
+				cut, err := hasKeys(ctx, server, name)
+				if err != nil {
+					return validation{}, err
+				}
+				if !cut {
+					step("%s: no DNSKEY either, not a zone cut", name)
+					continue
+				}
+			}
+
+			return validation{
+				Verdict: verdictInsecure,
+				Reason:  fmt.Sprintf("no DS for %s in %s", name, zone),
+			}, nil
+		}
+
+		if err := verifyRRset(set, sigs, keys, zone); err != nil {
+			return validation{}, bogusf("%s DS: %v", name, err)
+		}
+		step("%s DS: %d records, signed by %s", name, len(set), zone)
+
+		var ds []*dns.DS
+		for _, rr := range set {
+			ds = append(ds, rr.(*dns.DS))
+		}
+
+		if !anySupported(ds) {
+			return validation{
+				Verdict: verdictInsecure,
+				Reason:  fmt.Sprintf("%s DS uses unsupported algorithms", name),
+			}, nil
+		}
+
+		if keys, err = fetchKeys(ctx, server, name, ds); err != nil {
+			return validation{}, err
+		}
+		step("%s DNSKEY: %d keys, signed by a key the DS vouches for", name, len(keys))
+		zone = name
+	}
+
+	return validation{Verdict: verdictSecure}, nil
+}
+
+func anySupported(ds []*dns.DS) bool {
+	for _, d := range ds {
+		if supportedAlgorithms[d.Algorithm] && supportedDigests[d.DigestType] {
+			return true
+		}
+	}
+	return false
+}
+
+func hasKeys(ctx context.Context, server, name string) (bool, error) {
+	r, err := queryCD(ctx, server, name, dns.TypeDNSKEY)
+	if err != nil {
+		return false, err
+	}
+	set, _ := rrsetOf(r.Answer, name, dns.TypeDNSKEY)
+	return len(set) > 0, nil
+}
+
+// fetchKeys gets the DNSKEY set for zone and checks that it's self-signed
+// by a key that one of ds vouches for.
+func fetchKeys(ctx context.Context, server, zone string, ds []*dns.DS) ([]*dns.DNSKEY, error) {
+	r, err := queryCD(ctx, server, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, rcodeError(zone+" DNSKEY", r.Rcode)
+	}
+
+	set, sigs := rrsetOf(r.Answer, zone, dns.TypeDNSKEY)
+	if len(set) == 0 {
+		return nil, bogusf("%s: DS but no DNSKEY", zone)
+	}
+
+	var keys, anchored []*dns.DNSKEY
+	for _, rr := range set {
+		k := rr.(*dns.DNSKEY)
+		keys = append(keys, k)
+		if matchesDS(k, ds) {
+			anchored = append(anchored, k)
+		}
+	}
+	if len(anchored) == 0 {
+		return nil, bogusf("%s: no DNSKEY matches DS", zone)
+	}
+
+	if err := verifyRRset(set, sigs, anchored, zone); err != nil {
+		return nil, bogusf("%s DNSKEY: %v", zone, err)
+	}
+	return keys, nil
+}
+
+func matchesDS(k *dns.DNSKEY, ds []*dns.DS) bool {
+	for _, d := range ds {
+		if d.KeyTag != k.KeyTag() || d.Algorithm != k.Algorithm {
+			continue
+		}
+		kd := k.ToDS(d.DigestType)
+		if kd != nil && strings.EqualFold(kd.Digest, d.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyRRset succeeds if any of sigs is a currently-valid signature over
+// set made by one of keys on behalf of signer.
+func verifyRRset(set []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, signer string) error {
+	if len(sigs) == 0 {
+		return fmt.Errorf("unsigned")
+	}
+
+	now := time.Now()
+	err := fmt.Errorf("no signature by a known key")
+	for _, sig := range sigs {
+		if dns.CanonicalName(sig.SignerName) != dns.CanonicalName(signer) {
+			continue
+		}
+		for _, k := range keys {
+			if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+				continue
+			}
+			if !sig.ValidityPeriod(now) {
+				err = fmt.Errorf("signature %d expired or not yet valid", sig.KeyTag)
+				continue
+			}
+			if verr := sig.Verify(k, set); verr != nil {
+				err = fmt.Errorf("signature %d: %w", sig.KeyTag, verr)
+				continue
+			}
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// serveDNS runs h on a local UDP+TCP port and returns its address.
+func serveDNS(t *testing.T, h dns.Handler) string {
+	t.Helper()
+	return serveDNSOn(t, "127.0.0.1:0", h)
+}
+
+func serveDNSOn(t *testing.T, addr string, h dns.Handler) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+
+	// cleanups run last-in first-out, so this waits for the shutdowns below
+	var wg sync.WaitGroup
+	t.Cleanup(wg.Wait)
+	for _, srv := range []*dns.Server{
+		{PacketConn: pc, Handler: h},
+		{Listener: l, Handler: h},
+	} {
+		wg.Add(1)
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go func() {
+			defer wg.Done()
+			srv.ActivateAndServe()
+		}()
+		<-started
+		t.Cleanup(func() { srv.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+type testKey struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestKey(t *testing.T, zone string, flags uint16) *testKey {
+	t.Helper()
+	k := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(zone),
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{key: k, priv: priv.(crypto.Signer)}
+}
+
+func (k *testKey) ds() *dns.DS {
+	return k.key.ToDS(dns.SHA256)
+}
+
+// testZones is a pretend recursive resolver serving a small signed tree
+// out of memory; it answers exactly what it's been given, with RRSIGs.
+type testZones struct {
+	mu  sync.Mutex
+	rrs map[string][]dns.RR
+}
+
+func newTestZones() *testZones {
+	return &testZones{rrs: map[string][]dns.RR{}}
+}
+
+func zoneKey(name string, qtype uint16) string {
+	return dns.CanonicalName(name) + "/" + dns.TypeToString[qtype]
+}
+
+func (z *testZones) add(rrs ...dns.RR) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	for _, rr := range rrs {
+		h := rr.Header()
+		k := zoneKey(h.Name, h.Rrtype)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			k = zoneKey(h.Name, sig.TypeCovered)
+		}
+		z.rrs[k] = append(z.rrs[k], rr)
+	}
+}
+
+// addSigned adds rrset along with a signature over it from signer.
+func (z *testZones) addSigned(t *testing.T, signer *testKey, rrset ...dns.RR) {
+	t.Helper()
+	z.add(rrset...)
+	z.add(testSign(t, signer, time.Now().Add(24*time.Hour), rrset...))
+}
+
+func testSign(t *testing.T, signer *testKey, exp time.Time, rrset ...dns.RR) *dns.RRSIG {
+	t.Helper()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  signer.key.Algorithm,
+		KeyTag:     signer.key.KeyTag(),
+		SignerName: signer.key.Hdr.Name,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(exp.Unix()),
+	}
+	if err := sig.Sign(signer.priv, rrset); err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func (z *testZones) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	m := new(dns.Msg)
+	m.SetReply(req)
+	q := req.Question[0]
+	m.Answer = append(m.Answer, z.rrs[zoneKey(q.Name, q.Qtype)]...)
+	if len(m.Answer) == 0 {
+		// a negative answer comes with the SOA of the zone it's from
+		for _, i := range append(dns.Split(q.Name), len(q.Name)-1) {
+			if soa := z.rrs[zoneKey(q.Name[i:], dns.TypeSOA)]; len(soa) > 0 {
+				m.Ns = append(m.Ns, soa...)
+				break
+			}
+		}
+	}
+	w.WriteMsg(m)
+}
+
+// signedTree builds root -> test. -> {good,bogus,nods,plain}.test. and points
+// the trust anchors at the fake root.
+func signedTree(t *testing.T) (*testZones, map[string]*testKey) {
+	t.Helper()
+	var (
+		z     = newTestZones()
+		root  = newTestKey(t, ".", 257)
+		tld   = newTestKey(t, "test.", 257)
+		good  = newTestKey(t, "good.test.", 257)
+		bogus = newTestKey(t, "bogus.test.", 257)
+		other = newTestKey(t, "bogus.test.", 257)
+		nods  = newTestKey(t, "nods.test.", 257)
+	)
+
+	z.addSigned(t, root, root.key)
+	z.addSigned(t, root, mustRR(t, ". 300 IN SOA a.root. admin.root. 1 3600 600 86400 300"))
+	z.addSigned(t, root, tld.ds())
+	z.addSigned(t, tld, tld.key)
+	z.addSigned(t, tld, mustRR(t, "test. 300 IN SOA ns.test. admin.test. 1 3600 600 86400 300"))
+	z.addSigned(t, tld, good.ds())
+	z.addSigned(t, good, good.key)
+
+	// the parent vouches for a key the child doesn't serve
+	z.addSigned(t, tld, other.ds())
+	z.addSigned(t, bogus, bogus.key)
+
+	// signed, but the parent was never told
+	z.addSigned(t, nods, nods.key)
+
+	old := rootAnchors
+	rootAnchors = []*dns.DS{root.ds()}
+	t.Cleanup(func() { rootAnchors = old })
+
+	return z, map[string]*testKey{
+		".":           root,
+		"test.":       tld,
+		"good.test.":  good,
+		"bogus.test.": bogus,
+	}
+}
+
+func TestValidateDomain(t *testing.T) {
+	z, _ := signedTree(t)
+	addr := serveDNS(t, z)
+	ctx := context.Background()
+
+	for name, want := range map[string]string{
+		"good.test":  verdictSecure,
+		"bogus.test": verdictBogus,
+		"plain.test": verdictInsecure,
+	} {
+		v := validateDomain(ctx, addr, name)
+		if v.Verdict != want {
+			t.Errorf("%s: want %s got %s (%s)", name, want, v.Verdict, v.Reason)
+		}
+	}
+}
+
+func TestValidateRetry(t *testing.T) {
+	fastRetries(t)
+	z, _ := signedTree(t)
+	addr := serveDNS(t, &flaky{n: 1, h: z})
+	usePool(t, addr, addr)
+
+	if v := validateRetry(context.Background(), "good.test"); v.Verdict != verdictSecure {
+		t.Fatalf("want secure after a retry, got %s: %s", v.Verdict, v.Reason)
+	}
+}
+
+func TestValidateDomainExpiredSignature(t *testing.T) {
+	z, keys := signedTree(t)
+	addr := serveDNS(t, z)
+
+	good := keys["good.test."]
+	expired := testSign(t, good, time.Now().Add(-time.Minute), good.key)
+	z.mu.Lock()
+	z.rrs[zoneKey("good.test.", dns.TypeDNSKEY)] = []dns.RR{good.key, expired}
+	z.mu.Unlock()
+
+	v := validateDomain(context.Background(), addr, "good.test")
+	if v.Verdict != verdictBogus || !strings.Contains(v.Reason, "expired") {
+		t.Fatalf("want bogus/expired, got %s (%s)", v.Verdict, v.Reason)
+	}
+}